@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_ACCOUNTS string = "accounts"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+func isRole(r Role) bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether an account with this role may use a route that
+// requires the given role. Roles are ordered viewer, editor, owner.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+func NewAccount(email string, name string, hash string, role Role) Account {
+	return Account{
+		ID:      uuid.New().String(),
+		Email:   normalizeEmail(email),
+		Name:    name,
+		Hash:    hash,
+		Role:    role,
+		Created: time.Now(),
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkAccounts makes sure emails are unique and at least one owner is
+// left.
+func checkAccounts(as []Account) error {
+	var (
+		owners int
+		emails = make(map[string]bool, len(as))
+	)
+
+	for _, v := range as {
+		if emails[v.Email] {
+			return ErrAccountExists
+		}
+		emails[v.Email] = true
+
+		if v.Role == RoleOwner {
+			owners++
+		}
+	}
+
+	if owners == 0 {
+		return ErrLastOwner
+	}
+
+	return nil
+}
+
+type contextKey int
+
+// Every value kept in a request context has its key here, so no two
+// collide.
+const (
+	accountContextKey contextKey = iota
+	sessionContextKey
+	apiKeyContextKey
+	cspNonceContextKey
+)
+
+func withAccount(ctx context.Context, a Account) context.Context {
+	return context.WithValue(ctx, accountContextKey, a)
+}
+
+// accountFrom returns the account that signed the request in.
+func accountFrom(ctx context.Context) (Account, bool) {
+	a, ok := ctx.Value(accountContextKey).(Account)
+	return a, ok
+}
+
+// Bolt
+func (db *cachedDatabase) GetAccounts(ctx context.Context) ([]Account, error) {
+	as := make([]Account, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		var err error
+		as, err = readAccounts(tx)
+		return err
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get accounts", zap.Error(err))
+		return as, ErrDatabase
+	}
+
+	return as, nil
+}
+
+func (db *cachedDatabase) GetAccount(ctx context.Context, id string) (Account, error) {
+	var a Account
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_ACCOUNTS))
+		if b == nil {
+			return ErrAccountNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrAccountNotFound
+		}
+
+		return json.Unmarshal(v, &a)
+	})
+
+	if err != nil && err != ErrAccountNotFound {
+		db.logger.Error("cannot get account", zap.Error(err))
+		return a, ErrDatabase
+	}
+
+	return a, err
+}
+
+func (db *cachedDatabase) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
+	as, err := db.GetAccounts(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+
+	email = normalizeEmail(email)
+	for _, v := range as {
+		if v.Email == email {
+			return v, nil
+		}
+	}
+
+	return Account{}, ErrAccountNotFound
+}
+
+func (db *cachedDatabase) PutAccount(account *Account) error {
+	account.Email = normalizeEmail(account.Email)
+
+	return db.updateAccounts(func(b *bolt.Bucket) error {
+		return save(b, []byte(account.ID), account)
+	})
+}
+
+// UpdateAccount applies a change to an account in one transaction.
+func (db *cachedDatabase) UpdateAccount(id string, fn func(a *Account) error) (Account, error) {
+	var a Account
+	err := db.updateAccounts(func(b *bolt.Bucket) error {
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrAccountNotFound
+		}
+
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+
+		if err := fn(&a); err != nil {
+			return err
+		}
+
+		a.Email = normalizeEmail(a.Email)
+
+		return save(b, []byte(id), a)
+	})
+
+	return a, err
+}
+
+func (db *cachedDatabase) DeleteAccount(id string) error {
+	return db.updateAccounts(func(b *bolt.Bucket) error {
+		if b.Get([]byte(id)) == nil {
+			return ErrAccountNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// ReplaceAccounts swaps every account for the given ones, e.g. when a site
+// is restored from an archive.
+func (db *cachedDatabase) ReplaceAccounts(accounts []Account) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		return replaceAccounts(tx, accounts)
+	})
+}
+
+func (t *boltTx) ReplaceAccounts(accounts []Account) error {
+	return replaceAccounts(t.tx, accounts)
+}
+
+func replaceAccounts(tx *bolt.Tx, accounts []Account) error {
+	return changeAccounts(tx, func(b *bolt.Bucket) error {
+		var keys [][]byte
+		b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for i := range accounts {
+			if err := save(b, []byte(accounts[i].ID), accounts[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateAccounts applies a change and rolls it back if it leaves the
+// accounts in an invalid state.
+func (db *cachedDatabase) updateAccounts(fn func(b *bolt.Bucket) error) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		return changeAccounts(tx, fn)
+	})
+}
+
+// changeAccounts applies a change within a transaction and fails if it
+// leaves the accounts in an invalid state.
+func changeAccounts(tx *bolt.Tx, fn func(b *bolt.Bucket) error) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_ACCOUNTS))
+	if err != nil {
+		return err
+	}
+
+	if err = fn(b); err != nil {
+		return err
+	}
+
+	as, err := readAccounts(tx)
+	if err != nil {
+		return err
+	}
+
+	return checkAccounts(as)
+}
+
+func readAccounts(tx *bolt.Tx) ([]Account, error) {
+	as := make([]Account, 0)
+
+	b := tx.Bucket([]byte(BUCKET_ACCOUNTS))
+	if b == nil {
+		return as, nil
+	}
+
+	err := b.ForEach(func(k, v []byte) error {
+		var a Account
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+
+		as = append(as, a)
+		return nil
+	})
+
+	return as, err
+}
+
+// SQLite
+func (db *sqliteDatabase) GetAccounts(ctx context.Context) ([]Account, error) {
+	as := make([]Account, 0)
+	err := db.queryRecords(ctx, `SELECT data FROM accounts ORDER BY email`, func(data []byte) error {
+		var a Account
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+
+		as = append(as, a)
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get accounts", zap.Error(err))
+		return as, ErrDatabase
+	}
+
+	return as, nil
+}
+
+func (db *sqliteDatabase) GetAccount(ctx context.Context, id string) (Account, error) {
+	return db.getAccount(ctx, `SELECT data FROM accounts WHERE id = ?`, id)
+}
+
+func (db *sqliteDatabase) GetAccountByEmail(ctx context.Context, email string) (Account, error) {
+	return db.getAccount(ctx, `SELECT data FROM accounts WHERE email = ?`, normalizeEmail(email))
+}
+
+func (db *sqliteDatabase) getAccount(ctx context.Context, query string, key string) (Account, error) {
+	var a Account
+	err := db.getRecord(ctx, query, key, &a)
+	if err == sql.ErrNoRows {
+		return a, ErrAccountNotFound
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get account", zap.Error(err))
+		return a, ErrDatabase
+	}
+
+	return a, nil
+}
+
+func (db *sqliteDatabase) PutAccount(account *Account) error {
+	account.Email = normalizeEmail(account.Email)
+
+	return db.updateAccounts(func(tx *sql.Tx) error {
+		return putAccount(tx, account)
+	})
+}
+
+func (db *sqliteDatabase) UpdateAccount(id string, fn func(a *Account) error) (Account, error) {
+	var a Account
+	err := db.updateAccounts(func(tx *sql.Tx) error {
+		var data string
+
+		err := tx.QueryRow(`SELECT data FROM accounts WHERE id = ?`, id).Scan(&data)
+		if err == sql.ErrNoRows {
+			return ErrAccountNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(data), &a); err != nil {
+			return err
+		}
+
+		if err := fn(&a); err != nil {
+			return err
+		}
+
+		a.Email = normalizeEmail(a.Email)
+
+		return putAccount(tx, &a)
+	})
+
+	return a, err
+}
+
+func (db *sqliteDatabase) DeleteAccount(id string) error {
+	return db.updateAccounts(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM accounts WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrAccountNotFound
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (db *sqliteDatabase) ReplaceAccounts(accounts []Account) error {
+	return db.update(func(tx *sql.Tx) error {
+		return replaceSqlAccounts(tx, accounts)
+	})
+}
+
+func (t *sqliteTx) ReplaceAccounts(accounts []Account) error {
+	return replaceSqlAccounts(t.tx, accounts)
+}
+
+func replaceSqlAccounts(tx *sql.Tx, accounts []Account) error {
+	return changeSqlAccounts(tx, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM accounts`); err != nil {
+			return err
+		}
+
+		for i := range accounts {
+			if err := putAccount(tx, &accounts[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *sqliteDatabase) updateAccounts(fn func(tx *sql.Tx) error) error {
+	return db.update(func(tx *sql.Tx) error {
+		return changeSqlAccounts(tx, fn)
+	})
+}
+
+func changeSqlAccounts(tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	as := make([]Account, 0)
+
+	rows, err := tx.Query(`SELECT data FROM accounts`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			a    Account
+			data []byte
+		)
+
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, &a); err != nil {
+			return err
+		}
+
+		as = append(as, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return checkAccounts(as)
+}
+
+func putAccount(tx *sql.Tx, account *Account) error {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM accounts WHERE email = ? AND id <> ?`, account.Email, account.ID).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return ErrAccountExists
+	}
+
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO accounts (id, email, data) VALUES (?, ?, ?)`, account.ID, account.Email, string(data))
+
+	return err
+}
@@ -16,7 +16,7 @@ import { Subscription } from 'rxjs/Rx';
 
 import { Router, NavigationEnd } from '@angular/router';
 
-import { AppState } from './app.service';
+import { AppState, Permanent } from './app.service';
 
 import { ApiService } from './shared/service/api.service';
 import { Emitter } from './shared/service/emitter.service';
@@ -139,6 +139,7 @@ export class AppComponent implements OnInit, OnDestroy, AfterViewInit {
     private router: Router,
     private renderer: Renderer,
     private state: AppState,
+    private permanent: Permanent,
     private api: ApiService
   ) {
 
@@ -184,8 +185,7 @@ export class AppComponent implements OnInit, OnDestroy, AfterViewInit {
 
           switch (error.code) {
             case 401:
-            case 403:
-              this.logout();
+              this.signOut();
               break;
           }
         })
@@ -197,7 +197,19 @@ export class AppComponent implements OnInit, OnDestroy, AfterViewInit {
   }
 
   public logout() {
+    // The tokens are dropped whether or not the server ended the session.
+    this.subs.push(
+      this.api.logout()
+        .finally(() => this.signOut())
+        .subscribe(() => { }, () => { })
+    );
+  }
+
+  private signOut() {
+    this.permanent.remove('token');
+    this.permanent.remove('refresh');
     this.state.delete('token');
+    this.state.delete('refresh');
     this.router.navigate(['/login']);
   }
 
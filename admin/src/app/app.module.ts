@@ -112,6 +112,7 @@ export class AppModule {
     public permanent: Permanent,
   ) {
     appState.set("token", permanent.get("token"));
+    appState.set("refresh", permanent.get("refresh"));
 
     if (isDevMode()) {
       appState.set("api.root", "http://localhost:8080/admin/");
@@ -77,6 +77,12 @@ export class Permanent {
     }
   }
 
+  public remove(prop: string) {
+    if (typeof (Storage) !== "undefined") {
+      localStorage.removeItem(prop);
+    }
+  }
+
 }
 
 @Injectable()
@@ -13,6 +13,9 @@ import { AppState, Permanent } from '../app.service';
 import { ApiService } from '../shared/service/api.service';
 
 import { LoginRequest } from '../shared/service/request/login';
+import { LoginVerifyRequest } from '../shared/service/request/login.verify';
+import { Token } from '../shared/service/response/token';
+import { Challenge } from '../shared/service/response/challenge';
 
 @Component({
   selector: 'login',
@@ -26,6 +29,10 @@ export class LoginComponent implements OnInit, OnDestroy {
     password: ''
   };
 
+  // verification is set once the password was accepted for an account
+  // that also needs a two-factor code.
+  public verification: LoginVerifyRequest = null;
+
   private returnUrl: string = '/';
 
   private subs: Subscription[] = [];
@@ -58,12 +65,42 @@ export class LoginComponent implements OnInit, OnDestroy {
   public login() {
     this.subs.push(
       this.api.login(this.model)
-        .subscribe(token => {
-          this.permanent.set('token', token);
-          this.state.set('token', token);
+        .subscribe(response => {
+          if ((response as Challenge).challenge) {
+            this.verification = {
+              challenge: (response as Challenge).challenge,
+              code: ''
+            };
+            return;
+          }
 
-          this.router.navigate([this.returnUrl]);
+          this.signIn(response as Token);
         })
     );
   }
+
+  public verify() {
+    this.subs.push(
+      this.api.verifyLogin(this.verification)
+        .subscribe(token => {
+          this.verification = null;
+          this.signIn(token);
+        })
+    );
+  }
+
+  // cancel goes back to the password step, e.g. after the challenge
+  // expired.
+  public cancel() {
+    this.verification = null;
+  }
+
+  private signIn(token: Token) {
+    this.permanent.set('token', token.accessToken);
+    this.permanent.set('refresh', token.refreshToken);
+    this.state.set('token', token.accessToken);
+    this.state.set('refresh', token.refreshToken);
+
+    this.router.navigate([this.returnUrl]);
+  }
 }
@@ -44,6 +44,8 @@ export class SettingsComponent implements OnInit, OnDestroy {
       email: '',
       password: '',
       password_repeat: '',
+      current_password: '',
+      code: '',
     },
     theme: { selected: '', themes: [] }
   };
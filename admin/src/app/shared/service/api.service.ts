@@ -7,6 +7,8 @@ import { AppState, Formatter } from '../../app.service';
 import { Observable } from 'rxjs/Rx';
 
 import { ApiResponse } from './response/api';
+import { Token } from './response/token';
+import { Challenge } from './response/challenge';
 import { Project } from '../domain/project';
 import { Content } from '../domain/content';
 import { Theme } from '../domain/theme';
@@ -30,6 +32,7 @@ import { MenuAddRequest } from './request/menu.add';
 import { MenuRemoveRequest } from './request/menu.remove';
 
 import { LoginRequest } from './request/login';
+import { LoginVerifyRequest } from './request/login.verify';
 
 @Injectable()
 export class ApiService {
@@ -67,6 +70,7 @@ export class ApiService {
     'site.get': 'site',
 
     'login': 'login',
+    'login.verify': 'login/verify',
     'logout': 'logout',
   };
 
@@ -205,12 +209,28 @@ export class ApiService {
     );
   }
 
-  public login(request: LoginRequest): Observable<string> {
-    return this.extract<string>(
+  // login answers with tokens, or with a challenge for accounts that use
+  // two-factor authentication, see verifyLogin.
+  public login(request: LoginRequest): Observable<Token | Challenge> {
+    return this.extract<Token | Challenge>(
       this.http.post(this.state.get("api.root") + this.endpoints['login'], request)
     );
   }
 
+  public verifyLogin(request: LoginVerifyRequest): Observable<Token> {
+    return this.extract<Token>(
+      this.http.post(this.state.get("api.root") + this.endpoints['login.verify'], request)
+    );
+  }
+
+  // logout ends the session on the server, so its refresh token stops
+  // working as well.
+  public logout(): Observable<boolean> {
+    return this.extract<boolean>(
+      this.http.post(this.state.get("api.root") + this.endpoints['logout'], {})
+    );
+  }
+
   public getSite(): Observable<string> {
     return this.extract<string>(
       this.http.get(this.state.get("api.root") + this.endpoints['site.get'])
@@ -6,11 +6,13 @@ import { HttpClient, HttpHeaders, HttpEvent, HttpInterceptor, HttpHandler, HttpR
 
 import { Observable } from 'rxjs/Rx';
 
-import { AppState } from '../../app.service';
+import { AppState, Permanent } from '../../app.service';
 
 import { Emitter } from './emitter.service';
 
 import { ApiResponse } from './response/api';
+import { Token } from './response/token';
+import { RefreshRequest } from './request/refresh';
 
 import { Error } from '../domain/error';
 
@@ -60,9 +62,17 @@ export class AuthorizationInterceptor implements HttpInterceptor {
 
   private errorChannel: string = 'error';
 
+  // The refresh in flight, shared by every request that failed meanwhile.
+  // Each refresh token can be used only once, so they must not race.
+  private refreshing: Observable<string> = null;
+
+  constructor(
+    private state: AppState,
+    private permanent: Permanent
+  ) { }
+
   intercept(req: HttpRequest<any>, next: HttpHandler): Observable<HttpEvent<any>> {
-    return next
-      .handle(req)
+    return this.send(req, next)
       .catch(err => {
         return Observable.of(err)
       })
@@ -70,10 +80,14 @@ export class AuthorizationInterceptor implements HttpInterceptor {
         if (event instanceof HttpResponse || event instanceof HttpErrorResponse) {
           switch (event.status) {
             case 401:
-              Emitter.get(this.errorChannel).emit(new Error('You entered incorrect email or password.', event.status));
+              if (this.invalidToken(event)) {
+                Emitter.get(this.errorChannel).emit(new Error('You are not logged in.', event.status));
+              } else {
+                Emitter.get(this.errorChannel).emit(new Error('You entered incorrect email or password.', event.status));
+              }
               break;
             case 403:
-              Emitter.get(this.errorChannel).emit(new Error('You are not logged in.', event.status));
+              Emitter.get(this.errorChannel).emit(new Error('You are not allowed to do that.', event.status));
               break;
             case 500:
               Emitter.get(this.errorChannel).emit(new Error('Critical error occured.', event.status));
@@ -84,4 +98,83 @@ export class AuthorizationInterceptor implements HttpInterceptor {
       });
   }
 
-}
\ No newline at end of file
+  // send retries a request refused for an expired access token once, with
+  // the token of a refreshed session.
+  private send(req: HttpRequest<any>, next: HttpHandler): Observable<HttpEvent<any>> {
+    return next
+      .handle(req)
+      .catch(err => {
+        if (this.invalidToken(err) && this.canRefresh(req)) {
+          return this.refresh(req, next)
+            .flatMap(token => next.handle(req.clone({ setHeaders: { 'Authorization': token } })));
+        }
+
+        return Observable.throw(err);
+      });
+  }
+
+  // invalidToken tells an expired or revoked access token, which a refresh
+  // may fix, from a refusal of the account itself.
+  private invalidToken(res: any): boolean {
+    return res instanceof HttpErrorResponse
+      && res.status === 401
+      && (res.headers.get('WWW-Authenticate') || '').indexOf('invalid_token') >= 0;
+  }
+
+  private canRefresh(req: HttpRequest<any>): boolean {
+    return req.headers.has('Authorization')
+      && !!this.state.get('refresh')
+      && !req.url.endsWith('/refresh');
+  }
+
+  private refresh(req: HttpRequest<any>, next: HttpHandler): Observable<string> {
+    if (!this.refreshing) {
+      let request = new HttpRequest<RefreshRequest>('POST', this.state.get('api.root') + 'refresh', {
+        refreshToken: this.state.get('refresh')
+      });
+
+      this.refreshing = next
+        .handle(request)
+        .filter(event => event instanceof HttpResponse)
+        .map((event: HttpResponse<ApiResponse<Token>>) => {
+          if (!event.body || event.body.error != null) {
+            throw event;
+          }
+
+          this.store(event.body.content);
+
+          return event.body.content.accessToken;
+        })
+        .catch(err => {
+          // The session is gone, answer like any request made without one.
+          this.store(null);
+
+          return Observable.throw(new HttpErrorResponse({
+            status: 401,
+            headers: new HttpHeaders({ 'WWW-Authenticate': 'Bearer error="invalid_token"' }),
+            url: req.url
+          }));
+        })
+        .finally(() => this.refreshing = null)
+        .publishReplay(1)
+        .refCount();
+    }
+
+    return this.refreshing;
+  }
+
+  private store(token: Token) {
+    if (token) {
+      this.permanent.set('token', token.accessToken);
+      this.permanent.set('refresh', token.refreshToken);
+      this.state.set('token', token.accessToken);
+      this.state.set('refresh', token.refreshToken);
+    } else {
+      this.permanent.remove('token');
+      this.permanent.remove('refresh');
+      this.state.delete('token');
+      this.state.delete('refresh');
+    }
+  }
+
+}
@@ -2,4 +2,6 @@ export interface CredentialsEditRequest {
 	email: string;
 	password: string;
 	password_repeat: string;
+	current_password: string;
+	code: string;
 }
\ No newline at end of file
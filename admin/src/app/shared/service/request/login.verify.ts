@@ -0,0 +1,4 @@
+export interface LoginVerifyRequest {
+	challenge: string;
+	code: string;
+}
@@ -0,0 +1,3 @@
+export interface RefreshRequest {
+	refreshToken: string;
+}
@@ -0,0 +1,4 @@
+export interface Challenge {
+	challenge: string;
+	expiresIn: number;
+}
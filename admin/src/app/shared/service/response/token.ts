@@ -0,0 +1,5 @@
+export interface Token {
+	accessToken: string;
+	refreshToken: string;
+	expiresIn: number;
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_APIKEYS string = "apikeys"
+
+	// APIKeyPrefix starts every key, so keys are told apart from access
+	// tokens and are easy to spot when they leak.
+	APIKeyPrefix string = "sck_"
+)
+
+var scopes = map[Scope]bool{
+	ScopeProjectsRead:     true,
+	ScopeProjectsWrite:    true,
+	ScopeContentRead:      true,
+	ScopeContentWrite:     true,
+	ScopeMenuRead:         true,
+	ScopeMenuWrite:        true,
+	ScopeSiteRead:         true,
+	ScopeSiteWrite:        true,
+	ScopeTrashRead:        true,
+	ScopeTrashWrite:       true,
+	ScopeMediaWrite:       true,
+	ScopeBackupRead:       true,
+	ScopeBackupWrite:      true,
+	ScopeMaintenanceRead:  true,
+	ScopeMaintenanceWrite: true,
+	ScopeAuditRead:        true,
+}
+
+func isScope(s Scope) bool {
+	return scopes[s]
+}
+
+// Covers reports whether a key granted this scope may use a route that
+// requires the given one. Write access to a resource includes reading it.
+func (s Scope) Covers(required Scope) bool {
+	if s == required {
+		return true
+	}
+
+	resource := strings.TrimSuffix(string(required), ":read")
+
+	return resource != string(required) && string(s) == resource+":write"
+}
+
+// NewAPIKey creates a key acting for an account and returns it together
+// with the secret, which is not kept.
+func NewAPIKey(account string, name string, scopes []Scope) (APIKey, string) {
+	var (
+		id     = uuid.New().String()
+		secret = GenerateSecret(32)
+	)
+
+	return APIKey{
+		ID:      id,
+		Account: account,
+		Name:    name,
+		Hash:    hashAPIKeySecret(secret),
+		Scopes:  scopes,
+		Created: time.Now(),
+	}, APIKeyPrefix + id + "." + secret
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a key into its id and secret.
+func parseAPIKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// authenticateAPIKey returns the stored key a presented one belongs to.
+func authenticateAPIKey(ctx context.Context, db DB, key string) (APIKey, bool) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return APIKey{}, false
+	}
+
+	k, err := db.GetAPIKey(ctx, id)
+	if err != nil {
+		return APIKey{}, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		return APIKey{}, false
+	}
+
+	return k, true
+}
+
+func (k APIKey) Allows(required Scope) bool {
+	for _, v := range k.Scopes {
+		if v.Covers(required) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withAPIKey(ctx context.Context, k APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, k)
+}
+
+// apiKeyFrom returns the API key a request was made with.
+func apiKeyFrom(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(apiKeyContextKey).(APIKey)
+	return k, ok
+}
+
+func sortAPIKeys(ks []APIKey) {
+	sort.Slice(ks, func(i, j int) bool {
+		return ks[i].Created.Before(ks[j].Created)
+	})
+}
+
+// Bolt
+func (db *cachedDatabase) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ks := make([]APIKey, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_APIKEYS))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var a APIKey
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+
+			ks = append(ks, a)
+			return nil
+		})
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get api keys", zap.Error(err))
+		return ks, ErrDatabase
+	}
+
+	sortAPIKeys(ks)
+
+	return ks, nil
+}
+
+func (db *cachedDatabase) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	var k APIKey
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_APIKEYS))
+		if b == nil {
+			return ErrAPIKeyNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrAPIKeyNotFound
+		}
+
+		return json.Unmarshal(v, &k)
+	})
+
+	if err != nil && err != ErrAPIKeyNotFound {
+		db.logger.Error("cannot get api key", zap.Error(err))
+		return k, ErrDatabase
+	}
+
+	return k, err
+}
+
+func (db *cachedDatabase) PutAPIKey(key *APIKey) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_APIKEYS))
+		if err != nil {
+			return err
+		}
+
+		return save(b, []byte(key.ID), key)
+	})
+}
+
+// TouchAPIKey updates the last-used time of a key that still exists.
+func (db *cachedDatabase) TouchAPIKey(id string, t time.Time) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_APIKEYS))
+		if b == nil {
+			return ErrAPIKeyNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrAPIKeyNotFound
+		}
+
+		var k APIKey
+		if err := json.Unmarshal(v, &k); err != nil {
+			return err
+		}
+
+		k.LastUsed = t
+
+		return save(b, []byte(id), k)
+	})
+}
+
+func (db *cachedDatabase) DeleteAPIKey(id string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_APIKEYS))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrAPIKeyNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// SQLite
+func (db *sqliteDatabase) GetAPIKeys(ctx context.Context) ([]APIKey, error) {
+	ks := make([]APIKey, 0)
+	err := db.queryRecords(ctx, `SELECT data FROM apikeys`, func(data []byte) error {
+		var k APIKey
+		if err := json.Unmarshal(data, &k); err != nil {
+			return err
+		}
+
+		ks = append(ks, k)
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get api keys", zap.Error(err))
+		return ks, ErrDatabase
+	}
+
+	sortAPIKeys(ks)
+
+	return ks, nil
+}
+
+func (db *sqliteDatabase) GetAPIKey(ctx context.Context, id string) (APIKey, error) {
+	var k APIKey
+	err := db.getRecord(ctx, `SELECT data FROM apikeys WHERE id = ?`, id, &k)
+	if err == sql.ErrNoRows {
+		return k, ErrAPIKeyNotFound
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get api key", zap.Error(err))
+		return k, ErrDatabase
+	}
+
+	return k, nil
+}
+
+func (db *sqliteDatabase) PutAPIKey(key *APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO apikeys (id, data) VALUES (?, ?)`, key.ID, string(data))
+		return err
+	})
+}
+
+func (db *sqliteDatabase) TouchAPIKey(id string, t time.Time) error {
+	return db.update(func(tx *sql.Tx) error {
+		var (
+			k    APIKey
+			data string
+		)
+
+		err := tx.QueryRow(`SELECT data FROM apikeys WHERE id = ?`, id).Scan(&data)
+		if err == sql.ErrNoRows {
+			return ErrAPIKeyNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(data), &k); err != nil {
+			return err
+		}
+
+		k.LastUsed = t
+
+		b, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE apikeys SET data = ? WHERE id = ?`, string(b), id)
+		return err
+	})
+}
+
+func (db *sqliteDatabase) DeleteAPIKey(id string) error {
+	return db.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM apikeys WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrAPIKeyNotFound
+			}
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ArchiveManifestFile string = "manifest.json"
+	ArchiveRecordsPath  string = "records"
+
+	// ArchiveMaxEntrySize caps each file of an archive and ArchiveMaxSize
+	// all of them together, so an archive cannot fill the disk.
+	ArchiveMaxEntrySize int64 = 512 << 20
+	ArchiveMaxSize      int64 = 8 << 30
+)
+
+var (
+	archiveRecords = []string{
+		"user",
+		"configuration",
+		"accounts",
+		"menu",
+		"routes",
+		"projects",
+		"contents",
+	}
+)
+
+type ArchiveManifest struct {
+	SchemaVersion uint64    `json:"schema_version"`
+	Created       time.Time `json:"created"`
+	Projects      int       `json:"projects"`
+	Contents      int       `json:"contents"`
+	Media         int       `json:"media"`
+}
+
+// Archiver produces and restores portable site archives. An archive is a
+// gzipped tarball holding a manifest, every record as JSON under records/
+// and every media file under media/images and media/videos.
+type Archiver struct {
+	db     DB
+	mm     *MediaManager
+	logger *zap.Logger
+}
+
+func NewArchiver(db DB, mm *MediaManager, logger *zap.Logger) *Archiver {
+	return &Archiver{
+		db:     db,
+		mm:     mm,
+		logger: logger,
+	}
+}
+
+func (a *Archiver) Export(ctx context.Context, w io.Writer) error {
+	u, err := a.db.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	c, err := a.db.GetConfiguration(ctx)
+	if err != nil {
+		return err
+	}
+
+	as, err := a.db.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	m, err := a.db.GetMenu(ctx)
+	if err != nil {
+		return err
+	}
+
+	r, err := a.db.GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+
+	ps, err := a.db.GetProjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	cs, err := a.db.GetContents(ctx)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, p := range paths {
+		fs, _ := ioutil.ReadDir(filepath.Join(MediaPath, p))
+		for _, f := range fs {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+
+			files = append(files, path.Join(MediaPath, p, f.Name()))
+		}
+	}
+
+	var (
+		zw = gzip.NewWriter(w)
+		tw = tar.NewWriter(zw)
+	)
+
+	records := map[string]interface{}{
+		"user":          u,
+		"configuration": c,
+		"accounts":      as,
+		"menu":          m,
+		"routes":        r,
+		"projects":      ps,
+		"contents":      cs,
+	}
+
+	err = writeArchiveJson(tw, ArchiveManifestFile, ArchiveManifest{
+		SchemaVersion: SchemaVersion(),
+		Created:       time.Now(),
+		Projects:      len(ps),
+		Contents:      len(cs),
+		Media:         len(files),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range archiveRecords {
+		err = writeArchiveJson(tw, path.Join(ArchiveRecordsPath, k+".json"), records[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range files {
+		err = writeArchiveFile(tw, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// Import validates an archive and restores it. Media files are extracted
+// to a temporary directory first and only moved into place once the whole
+// archive has been read, files that would overwrite different local files
+// are renamed and every reference to them is remapped. Records are written
+// in one unit of work, a failed import leaves the site as it was.
+func (a *Archiver) Import(ctx context.Context, r io.Reader) (ArchiveManifest, error) {
+	var (
+		manifest      ArchiveManifest
+		user          User
+		configuration Configuration
+		accounts      []Account
+		credentials   Credentials
+		menu          Menu
+		routes        map[string]Route
+		projects      []Project
+		contents      []Content
+	)
+
+	var (
+		records = map[string]interface{}{
+			ArchiveManifestFile:                                 &manifest,
+			path.Join(ArchiveRecordsPath, "user.json"):          &user,
+			path.Join(ArchiveRecordsPath, "configuration.json"): &configuration,
+			path.Join(ArchiveRecordsPath, "accounts.json"):      &accounts,
+			path.Join(ArchiveRecordsPath, "credentials.json"):   &credentials,
+			path.Join(ArchiveRecordsPath, "menu.json"):          &menu,
+			path.Join(ArchiveRecordsPath, "routes.json"):        &routes,
+			path.Join(ArchiveRecordsPath, "projects.json"):      &projects,
+			path.Join(ArchiveRecordsPath, "contents.json"):      &contents,
+		}
+		seen  = make(map[string]bool)
+		media = make(map[string]string)
+		total int64
+	)
+
+	tmp, err := ioutil.TempDir(MediaPath, ".import-")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(tmp)
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, ErrInvalidArchive
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, ErrInvalidArchive
+		}
+
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := path.Clean(h.Name)
+
+		limit := ArchiveMaxSize - total
+		if limit > ArchiveMaxEntrySize {
+			limit = ArchiveMaxEntrySize
+		}
+
+		if h.Size > limit {
+			return manifest, ErrArchiveTooLarge
+		}
+
+		if v, ok := records[name]; ok {
+			if err := json.NewDecoder(io.LimitReader(tr, limit)).Decode(v); err != nil {
+				return manifest, ErrInvalidArchive
+			}
+
+			total += h.Size
+
+			seen[name] = true
+			continue
+		}
+
+		dir, file := path.Split(name)
+		if !isArchiveMediaDir(strings.TrimSuffix(dir, "/")) || file == "" || strings.HasPrefix(file, ".") {
+			a.logger.Warn("skipping unknown archive entry", zap.String("name", h.Name))
+			continue
+		}
+
+		p := filepath.Join(tmp, filepath.Base(dir), file)
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return manifest, err
+		}
+
+		n, err := extractArchiveFile(tr, p, limit)
+		if err != nil {
+			return manifest, err
+		}
+
+		total += n
+
+		media[name] = p
+	}
+
+	// Archives of schema version 3 and older hold a single login instead of
+	// accounts.
+	if seen[path.Join(ArchiveRecordsPath, "credentials.json")] && !seen[path.Join(ArchiveRecordsPath, "accounts.json")] {
+		if credentials.Email != "" {
+			accounts = append(accounts, NewAccount(credentials.Email, "", credentials.Hash, RoleOwner))
+		}
+
+		seen[path.Join(ArchiveRecordsPath, "accounts.json")] = true
+	}
+	seen[path.Join(ArchiveRecordsPath, "credentials.json")] = true
+
+	for k := range records {
+		if !seen[k] {
+			a.logger.Error("archive is incomplete", zap.String("missing", k))
+			return manifest, ErrInvalidArchive
+		}
+	}
+
+	if manifest.SchemaVersion > SchemaVersion() {
+		return manifest, ErrSchemaTooNew
+	}
+
+	remap := make(map[string]string, len(media))
+	for k, v := range media {
+		p, err := a.mm.Place(v, filepath.Base(path.Dir(k)), path.Base(k))
+		if err != nil {
+			return manifest, err
+		}
+
+		remap[k] = p
+	}
+
+	var refs []*Media
+	{
+		refs = append(refs, userMedia(&user)...)
+		for i := range projects {
+			refs = append(refs, projectMedia(&projects[i])...)
+		}
+		for i := range contents {
+			refs = append(refs, contentMedia(&contents[i])...)
+		}
+	}
+
+	for _, m := range refs {
+		if m.Path == "" {
+			continue
+		}
+
+		if p, ok := remap[filepath.ToSlash(m.Path)]; ok {
+			m.Path = p
+			continue
+		}
+
+		a.logger.Warn("archive references missing media", zap.String("path", m.Path))
+		*m = Media{}
+	}
+
+	current, err := a.db.GetConfiguration(ctx)
+	if err != nil {
+		return manifest, err
+	}
+
+	configuration.JwtSecret = current.JwtSecret
+
+	if _, ok := NewThemeScanner().LoadThemes()[configuration.CurrentThemePath]; !ok {
+		configuration.CurrentThemePath = current.CurrentThemePath
+		configuration.CurrentTheme = current.CurrentTheme
+	}
+
+	// Media placed above and left unused by a failed import is removed by
+	// the media collector.
+	err = a.db.Atomic(func(tx Tx) error {
+		if err := tx.PutConfiguration(&configuration); err != nil {
+			return err
+		}
+
+		if len(accounts) > 0 {
+			if err := tx.ReplaceAccounts(accounts); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.PutUser(&user); err != nil {
+			return err
+		}
+
+		if err := replaceProjects(tx, projects); err != nil {
+			return err
+		}
+
+		if err := replaceContents(tx, contents); err != nil {
+			return err
+		}
+
+		if err := replaceRoutes(tx, routes); err != nil {
+			return err
+		}
+
+		return tx.PutMenu(&menu)
+	})
+
+	if err != nil {
+		return manifest, err
+	}
+
+	a.logger.Info("archive imported",
+		zap.Int("projects", len(projects)),
+		zap.Int("contents", len(contents)),
+		zap.Int("media", len(media)))
+
+	return manifest, nil
+}
+
+func (a *Archiver) ExportFile(ctx context.Context, p string) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.Export(ctx, f)
+}
+
+func (a *Archiver) ImportFile(ctx context.Context, p string) (ArchiveManifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	defer f.Close()
+
+	return a.Import(ctx, f)
+}
+
+func replaceProjects(tx Tx, projects []Project) error {
+	existing, err := tx.GetProjects()
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(projects))
+	for i := range projects {
+		keep[projects[i].Slug] = true
+
+		if err := tx.PutProject(&projects[i]); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range existing {
+		if keep[p.Slug] {
+			continue
+		}
+
+		if err := tx.DeleteProject(p.Slug); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replaceContents(tx Tx, contents []Content) error {
+	existing, err := tx.GetContents()
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(contents))
+	for i := range contents {
+		keep[contents[i].Slug] = true
+
+		if err := tx.PutContent(&contents[i]); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range existing {
+		if keep[c.Slug] {
+			continue
+		}
+
+		if err := tx.DeleteContent(c.Slug); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replaceRoutes(tx Tx, routes map[string]Route) error {
+	existing, err := tx.GetRoutes()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range routes {
+		route := v
+		if err := tx.PutRoute(&route); err != nil {
+			return err
+		}
+	}
+
+	for slug := range existing {
+		if _, ok := routes[slug]; ok {
+			continue
+		}
+
+		if err := tx.DeleteRoute(slug); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isArchiveMediaDir(dir string) bool {
+	for _, p := range paths {
+		if dir == path.Join(MediaPath, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeArchiveJson(tw *tar.Writer, name string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(buf)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(buf)
+
+	return err
+}
+
+func writeArchiveFile(tw *tar.Writer, name string) error {
+	f, err := os.Open(filepath.FromSlash(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+
+	return err
+}
+
+// extractArchiveFile writes an archive entry to p, failing once it grows
+// past limit bytes whatever its header claimed.
+func extractArchiveFile(r io.Reader, p string, limit int64) (int64, error) {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		return n, err
+	}
+
+	if n > limit {
+		return n, ErrArchiveTooLarge
+	}
+
+	return n, nil
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_AUDIT string = "audit"
+
+	AuditRetention     time.Duration = 90 * 24 * time.Hour
+	AuditPruneInterval time.Duration = 24 * time.Hour
+
+	AuditPageSize    int = 50
+	AuditMaxPageSize int = 500
+
+	// auditBodyLimit caps the request bodies read for the fields they
+	// set, larger ones are recorded without.
+	auditBodyLimit int64 = 1 << 20
+
+	// auditErrorLimit caps the part of a response kept to find its error.
+	auditErrorLimit int = 512
+
+	AuditLogin        string = "login"
+	AuditLoginLockout string = "login.lockout"
+	AuditPasswordSet  string = "password.reset"
+)
+
+// AuditLog records who did what in the admin. Entries older than the
+// retention are pruned.
+type AuditLog struct {
+	db        DB
+	logger    *zap.Logger
+	retention time.Duration
+	reads     bool
+	stop      chan bool
+}
+
+func NewAuditLog(db DB, logger *zap.Logger, retention time.Duration, reads bool) *AuditLog {
+	return &AuditLog{
+		db:        db,
+		logger:    logger,
+		retention: retention,
+		reads:     reads,
+		stop:      make(chan bool),
+	}
+}
+
+// covers tells whether requests to a protected route are recorded. Changes
+// and reads handing out the whole site always are, other reads unless the
+// log was told to skip them.
+func (l *AuditLog) covers(f RouteHandler) bool {
+	return l.reads || f.Method != "GET" || f.AuditReads
+}
+
+// Record stores an entry. A failure is logged, it never fails the action
+// itself.
+func (l *AuditLog) Record(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if err := l.db.PutAuditEntry(&e); err != nil {
+		l.logger.Error("cannot record audit entry", zap.String("action", e.Action), zap.Error(err))
+	}
+}
+
+func (l *AuditLog) Run() {
+	l.Expire()
+
+	ticker := time.NewTicker(AuditPruneInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				l.Expire()
+			case <-l.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (l *AuditLog) Expire() {
+	n, err := l.db.DeleteAuditEntriesBefore(time.Now().Add(-l.retention))
+	if err != nil {
+		l.logger.Error("cannot prune audit log", zap.Error(err))
+		return
+	}
+
+	if n > 0 {
+		l.logger.Info("audit log pruned", zap.Int("entries", n))
+	}
+}
+
+func (l *AuditLog) Finalize() {
+	l.stop <- true
+}
+
+// auditAction names the action of an admin route after its fixed path
+// segments, e.g. /admin/project/{slug}/update is project.update.
+func auditAction(path string) string {
+	var parts []string
+	for _, v := range strings.Split(strings.TrimPrefix(path, "/admin/"), "/") {
+		if v != "" && !strings.HasPrefix(v, "{") {
+			parts = append(parts, v)
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// newAuditEntry fills in who made a request and from where.
+func newAuditEntry(r *http.Request, action string) AuditEntry {
+	e := AuditEntry{
+		Time:   time.Now(),
+		Action: action,
+		IP:     clientIP(r),
+	}
+
+	if a, ok := accountFrom(r.Context()); ok {
+		e.Actor = a.Email
+	}
+
+	if k, ok := apiKeyFrom(r.Context()); ok {
+		e.APIKey = k.ID
+	}
+
+	return e
+}
+
+// readAuditFields returns the fields a JSON request body sets, leaving the
+// body intact for the handler. Values are not kept, they may be secrets.
+func readAuditFields(r *http.Request) map[string]json.RawMessage {
+	if r.Body == nil || r.Method == "GET" || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, auditBodyLimit+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+
+	if err != nil || int64(len(b)) > auditBodyLimit {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) != nil {
+		return nil
+	}
+
+	return fields
+}
+
+// auditTarget is the slug or id a request acts on, taken from the route
+// or else from the request body.
+func auditTarget(r *http.Request, fields map[string]json.RawMessage) string {
+	vars := mux.Vars(r)
+	for _, k := range []string{"slug", "id"} {
+		if v := vars[k]; v != "" {
+			return v
+		}
+	}
+
+	for _, k := range []string{"slug", "title", "email", "name"} {
+		var v string
+		if json.Unmarshal(fields[k], &v) == nil && v != "" {
+			if k == "title" {
+				return GenerateSlug(v)
+			}
+			return v
+		}
+	}
+
+	return ""
+}
+
+func auditChanges(fields map[string]json.RawMessage) []string {
+	changes := make([]string, 0, len(fields))
+	for k := range fields {
+		changes = append(changes, k)
+	}
+
+	sort.Strings(changes)
+
+	return changes
+}
+
+// auditResponseWriter keeps the status and the start of a response, enough
+// to tell whether the handler failed.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	head   []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if n := auditErrorLimit - len(w.head); n > 0 {
+		if n > len(b) {
+			n = len(b)
+		}
+		w.head = append(w.head, b[:n]...)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// failure returns the error a handler answered with, if any.
+func (w *auditResponseWriter) failure() string {
+	if w.status >= http.StatusBadRequest {
+		if msg := strings.TrimSpace(string(w.head)); msg != "" {
+			return msg
+		}
+		return http.StatusText(w.status)
+	}
+
+	if !bytes.HasPrefix(w.head, []byte(`{"error"`)) {
+		return ""
+	}
+
+	var r GenericResponse
+	if json.Unmarshal(w.head, &r) != nil || r.Error == "" {
+		return "error"
+	}
+
+	return r.Error
+}
+
+// matches reports whether an entry passes a filter. Actions match with
+// their sub-actions, project matches project.update.
+func (f AuditFilter) matches(e AuditEntry) bool {
+	if f.Actor != "" && e.Actor != normalizeEmail(f.Actor) {
+		return false
+	}
+
+	if f.Action != "" && e.Action != f.Action && !strings.HasPrefix(e.Action, f.Action+".") {
+		return false
+	}
+
+	if f.Target != "" && e.Target != f.Target {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+func (f AuditFilter) limit() int {
+	if f.Limit <= 0 {
+		return AuditPageSize
+	}
+
+	if f.Limit > AuditMaxPageSize {
+		return AuditMaxPageSize
+	}
+
+	return f.Limit
+}
+
+// Bolt
+func (db *cachedDatabase) PutAuditEntry(e *AuditEntry) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_AUDIT))
+		if err != nil {
+			return err
+		}
+
+		if e.ID, err = b.NextSequence(); err != nil {
+			return err
+		}
+
+		return save(b, itob(e.ID), e)
+	})
+}
+
+// GetAuditEntries returns the newest entries that pass a filter, older
+// than the entry f.Before if given.
+func (db *cachedDatabase) GetAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	es := make([]AuditEntry, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_AUDIT))
+		if b == nil {
+			return nil
+		}
+
+		var (
+			c    = b.Cursor()
+			k, v []byte
+		)
+
+		// Every entry is older than a cursor past the last one.
+		if f.Before == 0 {
+			k, v = c.Last()
+		} else if k, _ = c.Seek(itob(f.Before)); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		for ; k != nil && len(es) < f.limit(); k, v = c.Prev() {
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+
+			// Entries are kept in the order they happened.
+			if !f.Since.IsZero() && e.Time.Before(f.Since) {
+				break
+			}
+
+			if f.matches(e) {
+				es = append(es, e)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get audit entries", zap.Error(err))
+		return es, ErrDatabase
+	}
+
+	return es, nil
+}
+
+func (db *cachedDatabase) DeleteAuditEntriesBefore(t time.Time) (int, error) {
+	var n int
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_AUDIT))
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+
+			if !e.Time.Before(t) {
+				break
+			}
+
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		n = len(keys)
+		return nil
+	})
+
+	return n, err
+}
+
+// SQLite
+func (db *sqliteDatabase) PutAuditEntry(e *AuditEntry) error {
+	return db.update(func(tx *sql.Tx) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`INSERT INTO audit (time, actor, action, target, data) VALUES (?, ?, ?, ?, ?)`,
+			e.Time.UnixNano(), e.Actor, e.Action, e.Target, string(data))
+		if err != nil {
+			return err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		e.ID = uint64(id)
+
+		// The id is only known once inserted, store it with the entry.
+		data, err = json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE audit SET data = ? WHERE id = ?`, string(data), id)
+		return err
+	})
+}
+
+func (db *sqliteDatabase) GetAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if f.Before > 0 {
+		where, args = append(where, `id < ?`), append(args, f.Before)
+	}
+
+	if f.Actor != "" {
+		where, args = append(where, `actor = ?`), append(args, normalizeEmail(f.Actor))
+	}
+
+	if f.Action != "" {
+		where, args = append(where, `(action = ? OR substr(action, 1, ?) = ?)`), append(args, f.Action, len(f.Action)+1, f.Action+".")
+	}
+
+	if f.Target != "" {
+		where, args = append(where, `target = ?`), append(args, f.Target)
+	}
+
+	if !f.Since.IsZero() {
+		where, args = append(where, `time >= ?`), append(args, f.Since.UnixNano())
+	}
+
+	if !f.Until.IsZero() {
+		where, args = append(where, `time < ?`), append(args, f.Until.UnixNano())
+	}
+
+	query := `SELECT data FROM audit`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.limit())
+
+	es := make([]AuditEntry, 0)
+	err := db.queryRecords(ctx, query, func(data []byte) error {
+		var e AuditEntry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+
+		es = append(es, e)
+		return nil
+	}, args...)
+
+	if err != nil {
+		db.logger.Error("cannot get audit entries", zap.Error(err))
+		return es, ErrDatabase
+	}
+
+	return es, nil
+}
+
+func (db *sqliteDatabase) DeleteAuditEntriesBefore(t time.Time) (int, error) {
+	var n int64
+	err := db.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM audit WHERE time < ?`, t.UnixNano())
+		if err != nil {
+			return err
+		}
+
+		n, err = res.RowsAffected()
+		return err
+	})
+
+	return int(n), err
+}
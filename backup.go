@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BackupPath            string        = "backups"
+	BackupPrefix          string        = "showcase-"
+	BackupExtension       string        = ".db"
+	SqliteBackupExtension string        = ".sqlite"
+	BackupTimeFormat      string        = "20060102-150405"
+	BackupInterval        time.Duration = time.Hour * 24
+)
+
+// Snapshotter takes consistent copies of a live database.
+type Snapshotter interface {
+	Snapshot(p string) error
+	WriteTo(w io.Writer) (int64, error)
+
+	// Extension names the file format of a snapshot.
+	Extension() string
+}
+
+type boltSnapshotter struct {
+	bolt *bolt.DB
+}
+
+func NewBoltSnapshotter(bolt *bolt.DB) Snapshotter {
+	return &boltSnapshotter{
+		bolt: bolt,
+	}
+}
+
+// Snapshot copies the database inside a read transaction, so writers are
+// not blocked while it is copied.
+func (s *boltSnapshotter) Snapshot(p string) error {
+	return s.bolt.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(p, 0600)
+	})
+}
+
+func (s *boltSnapshotter) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	err := s.bolt.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+func (s *boltSnapshotter) Extension() string {
+	return BackupExtension
+}
+
+// RetentionPolicy decides which snapshots survive pruning. The newest
+// snapshot of each of the last Daily days and of each of the last Weekly
+// ISO weeks is kept, everything else is removed. The newest snapshot is
+// kept whatever the policy says.
+type RetentionPolicy struct {
+	Daily, Weekly int
+}
+
+type BackupScheduler struct {
+	source    Snapshotter
+	logger    *zap.Logger
+	dir       string
+	interval  time.Duration
+	retention RetentionPolicy
+	stop      chan bool
+}
+
+func NewBackupScheduler(source Snapshotter, logger *zap.Logger, dir string, interval time.Duration, retention RetentionPolicy) *BackupScheduler {
+	return &BackupScheduler{
+		source:    source,
+		logger:    logger,
+		dir:       dir,
+		interval:  interval,
+		retention: retention,
+		stop:      make(chan bool),
+	}
+}
+
+func (b *BackupScheduler) Run() {
+	if l, ok := b.latest(); !ok || time.Since(l) >= b.interval {
+		b.scheduled()
+	}
+
+	ticker := time.NewTicker(b.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				b.scheduled()
+			case <-b.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (b *BackupScheduler) scheduled() {
+	p, err := b.Backup()
+	if err != nil {
+		b.logger.Error("cannot create backup", zap.Error(err))
+		return
+	}
+
+	b.logger.Info("backup", zap.String("path", p))
+
+	if err = b.Prune(); err != nil {
+		b.logger.Error("cannot prune backups", zap.Error(err))
+	}
+}
+
+// Backup writes a consistent snapshot of the live database into the
+// backup directory without stopping the server.
+func (b *BackupScheduler) Backup() (string, error) {
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return "", err
+	}
+
+	var (
+		p   = filepath.Join(b.dir, BackupPrefix+time.Now().Format(BackupTimeFormat)+b.Extension())
+		tmp = p + ".tmp"
+	)
+
+	err := b.source.Snapshot(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return p, os.Rename(tmp, p)
+}
+
+// Extension names the file format of the snapshots.
+func (b *BackupScheduler) Extension() string {
+	return b.source.Extension()
+}
+
+// WriteTo streams a consistent snapshot of the live database.
+func (b *BackupScheduler) WriteTo(w io.Writer) (int64, error) {
+	return b.source.WriteTo(w)
+}
+
+// Prune removes every snapshot that is not retained by the policy.
+func (b *BackupScheduler) Prune() error {
+	backups := b.list()
+
+	var (
+		days  = make(map[string]bool)
+		weeks = make(map[string]bool)
+	)
+
+	for i, t := range backups {
+		var (
+			keep = i == 0
+			day  = t.Format("2006-01-02")
+			y, w = t.ISOWeek()
+			week = fmt.Sprintf("%d-%02d", y, w)
+		)
+
+		if !days[day] && len(days) < b.retention.Daily {
+			days[day] = true
+			keep = true
+		}
+
+		if !weeks[week] && len(weeks) < b.retention.Weekly {
+			weeks[week] = true
+			keep = true
+		}
+
+		if keep {
+			continue
+		}
+
+		p := filepath.Join(b.dir, BackupPrefix+t.Format(BackupTimeFormat)+b.Extension())
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+
+		b.logger.Info("backup pruned", zap.String("path", p))
+	}
+
+	return nil
+}
+
+// list returns the creation times of all snapshots, newest first.
+func (b *BackupScheduler) list() []time.Time {
+	files, _ := ioutil.ReadDir(b.dir)
+
+	var (
+		backups []time.Time
+		ext     = b.Extension()
+	)
+
+	for _, f := range files {
+		n := f.Name()
+		if f.IsDir() || !strings.HasPrefix(n, BackupPrefix) || !strings.HasSuffix(n, ext) {
+			continue
+		}
+
+		t, err := time.ParseInLocation(BackupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(n, BackupPrefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, t)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].After(backups[j])
+	})
+
+	return backups
+}
+
+func (b *BackupScheduler) latest() (time.Time, bool) {
+	backups := b.list()
+	if len(backups) == 0 {
+		return time.Time{}, false
+	}
+
+	return backups[0], true
+}
+
+func (b *BackupScheduler) Finalize() {
+	b.stop <- true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,15 +14,23 @@ const (
 
 type Cache interface {
 	Get(k string) (interface{}, bool)
+	GetStale(k string) (interface{}, bool)
 	Set(k string, v interface{})
 	SetWithTime(k string, v interface{}, d time.Duration)
 	Delete(k string)
 	DeleteExpired()
 	Clear()
+	Stats() CacheStats
 
 	Finalizable
 }
 
+type CacheStats struct {
+	Entries                 int
+	Bytes                   int64
+	Hits, Misses, Evictions uint64
+}
+
 type Item struct {
 	Value   interface{}
 	Expires int64
@@ -37,6 +46,8 @@ type memoryCache struct {
 
 	expiration time.Duration
 
+	hits, misses, evictions uint64
+
 	worker worker
 }
 
@@ -58,19 +69,32 @@ func (cache *memoryCache) Get(k string) (interface{}, bool) {
 	item, f := cache.items[k]
 	if !f {
 		cache.RUnlock()
+		atomic.AddUint64(&cache.misses, 1)
 		return nil, false
 	}
 
 	if item.Expired() {
 		cache.RUnlock()
+		atomic.AddUint64(&cache.misses, 1)
 		return nil, false
 	}
 
 	cache.RUnlock()
+	atomic.AddUint64(&cache.hits, 1)
 
 	return item.Value, true
 }
 
+// GetStale returns a value even if it has expired, as long as it has not
+// been swept yet.
+func (cache *memoryCache) GetStale(k string) (interface{}, bool) {
+	cache.RLock()
+	item, f := cache.items[k]
+	cache.RUnlock()
+
+	return item.Value, f
+}
+
 func (cache *memoryCache) SetWithTime(k string, v interface{}, d time.Duration) {
 	cache.Lock()
 
@@ -124,12 +148,26 @@ func (cache *memoryCache) DeleteExpired() {
 	for k, v := range cache.items {
 		if v.Expired() {
 			delete(cache.items, k)
+			cache.evictions++
 		}
 	}
 
 	cache.Unlock()
 }
 
+// Stats reports no byte size, values are not measured by this cache.
+func (cache *memoryCache) Stats() CacheStats {
+	cache.RLock()
+	defer cache.RUnlock()
+
+	return CacheStats{
+		Entries:   len(cache.items),
+		Hits:      atomic.LoadUint64(&cache.hits),
+		Misses:    atomic.LoadUint64(&cache.misses),
+		Evictions: cache.evictions,
+	}
+}
+
 func (cache *memoryCache) Finalize() {
 	cache.worker.Stop()
 	cache.Clear()
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	LRUCacheEntries int   = 10000
+	LRUCacheBytes   int64 = 64 << 20
+
+	// entryOverhead approximates the bookkeeping of an entry, so many tiny
+	// values still count against the byte budget.
+	entryOverhead int64 = 64
+)
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires int64
+}
+
+func (e *lruEntry) expired(now int64) bool {
+	return e.expires != NoExpiration && now > e.expires
+}
+
+// lruCache is a Cache bounded by an entry count and an approximate byte
+// budget. The least recently used entries are evicted first once either
+// limit is reached.
+type lruCache struct {
+	sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	expiration time.Duration
+
+	hits, misses, evictions uint64
+
+	worker worker
+}
+
+func NewLRUCache(entries int, bytes int64, d time.Duration, e time.Duration) Cache {
+	c := &lruCache{
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: entries,
+		maxBytes:   bytes,
+		expiration: d,
+		worker:     NewWorker(e),
+	}
+
+	c.worker.Run(c)
+
+	return c
+}
+
+func (cache *lruCache) Get(k string) (interface{}, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	el, f := cache.items[k]
+	if !f {
+		cache.misses++
+		return nil, false
+	}
+
+	// Expired entries stay until they are swept, so they can still be
+	// served stale.
+	e := el.Value.(*lruEntry)
+	if e.expired(time.Now().UnixNano()) {
+		cache.misses++
+		return nil, false
+	}
+
+	cache.order.MoveToFront(el)
+	cache.hits++
+
+	return e.value, true
+}
+
+// GetStale returns a value even if it has expired, as long as it has not
+// been swept or evicted yet.
+func (cache *lruCache) GetStale(k string) (interface{}, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	el, f := cache.items[k]
+	if !f {
+		return nil, false
+	}
+
+	cache.order.MoveToFront(el)
+
+	return el.Value.(*lruEntry).value, true
+}
+
+func (cache *lruCache) Set(k string, v interface{}) {
+	cache.SetWithTime(k, v, cache.expiration)
+}
+
+// SetWithTime stores a value that expires after d, a d of zero or less
+// keeps it until it is evicted.
+func (cache *lruCache) SetWithTime(k string, v interface{}, d time.Duration) {
+	var e = &lruEntry{
+		key:     k,
+		value:   v,
+		size:    sizeOf(v) + int64(len(k)) + entryOverhead,
+		expires: NoExpiration,
+	}
+
+	if d > 0 {
+		e.expires = time.Now().Add(d).UnixNano()
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if el, f := cache.items[k]; f {
+		cache.remove(el)
+	}
+
+	cache.items[k] = cache.order.PushFront(e)
+	cache.bytes += e.size
+
+	for cache.order.Len() > 1 && cache.full() {
+		cache.remove(cache.order.Back())
+		cache.evictions++
+	}
+}
+
+func (cache *lruCache) full() bool {
+	return (cache.maxEntries > 0 && cache.order.Len() > cache.maxEntries) ||
+		(cache.maxBytes > 0 && cache.bytes > cache.maxBytes)
+}
+
+func (cache *lruCache) remove(el *list.Element) {
+	e := cache.order.Remove(el).(*lruEntry)
+
+	delete(cache.items, e.key)
+	cache.bytes -= e.size
+}
+
+func (cache *lruCache) Delete(k string) {
+	cache.Lock()
+
+	if el, f := cache.items[k]; f {
+		cache.remove(el)
+	}
+
+	cache.Unlock()
+}
+
+func (cache *lruCache) Clear() {
+	cache.Lock()
+
+	cache.items = make(map[string]*list.Element)
+	cache.order.Init()
+	cache.bytes = 0
+
+	cache.Unlock()
+}
+
+func (cache *lruCache) DeleteExpired() {
+	cache.Lock()
+
+	now := time.Now().UnixNano()
+	for el := cache.order.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*lruEntry).expired(now) {
+			cache.remove(el)
+			cache.evictions++
+		}
+		el = prev
+	}
+
+	cache.Unlock()
+}
+
+func (cache *lruCache) Stats() CacheStats {
+	cache.Lock()
+	defer cache.Unlock()
+
+	return CacheStats{
+		Entries:   cache.order.Len(),
+		Bytes:     cache.bytes,
+		Hits:      cache.hits,
+		Misses:    cache.misses,
+		Evictions: cache.evictions,
+	}
+}
+
+func (cache *lruCache) Finalize() {
+	cache.worker.Stop()
+	cache.Clear()
+}
+
+// sizeOf approximates the memory a value holds by its encoded size.
+func sizeOf(v interface{}) int64 {
+	switch t := v.(type) {
+	case string:
+		return int64(len(t))
+	case []byte:
+		return int64(len(t))
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return entryOverhead
+	}
+
+	return int64(len(b))
+}
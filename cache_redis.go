@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	RedisCacheAddr   string        = "redis://localhost:6379/0"
+	RedisCachePrefix string        = "showcase:"
+	RedisTimeout     time.Duration = time.Second
+	RedisPoolSize    int           = 8
+)
+
+var (
+	errRedisProtocol = errors.New("redis: malformed reply")
+	errRedisNil      = errors.New("redis: nil reply")
+)
+
+// cacheTypes names every type the database and server keep in the cache,
+// so values survive the round trip through Redis with their type intact.
+// The configuration is left out on purpose, it holds the secret signing
+// every token and must not leave the database.
+var cacheTypes = map[string]reflect.Type{
+	"string":   reflect.TypeOf(""),
+	"user":     reflect.TypeOf(User{}),
+	"project":  reflect.TypeOf(Project{}),
+	"projects": reflect.TypeOf([]Project{}),
+	"content":  reflect.TypeOf(Content{}),
+	"contents": reflect.TypeOf([]Content{}),
+	"menu":     reflect.TypeOf(Menu{}),
+	"routes":   reflect.TypeOf(map[string]Route{}),
+}
+
+func cacheTypeName(v interface{}) (string, bool) {
+	t := reflect.TypeOf(v)
+	for k, v := range cacheTypes {
+		if v == t {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+// redisEnvelope is the stored form of a value. Expires is kept next to the
+// value, Redis itself drops the key only after the eviction interval, the
+// same way expired entries linger in memory until they are swept.
+type redisEnvelope struct {
+	Type    string          `json:"type"`
+	Expires int64           `json:"expires"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// redisCache is a Cache shared by every instance connected to the same
+// Redis server. Failures are logged and treated as misses, the database
+// stays the source of truth.
+type redisCache struct {
+	dial   func() (net.Conn, error)
+	prefix string
+	pool   chan *redisConn
+	logger *zap.Logger
+
+	expiration time.Duration
+	linger     time.Duration
+
+	hits, misses uint64
+}
+
+// NewRedisCache connects to a server given as redis://[:password@]host:port/db.
+func NewRedisCache(addr string, prefix string, d time.Duration, logger *zap.Logger) (Cache, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "redis" {
+		return nil, ErrUnknownCache
+	}
+
+	var (
+		password, _ = u.User.Password()
+		db          = strings.TrimPrefix(u.Path, "/")
+	)
+
+	dial := func() (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", u.Host, RedisTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		c := newRedisConn(conn)
+		if password != "" {
+			if _, err := c.do("AUTH", password); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		if db != "" && db != "0" {
+			if _, err := c.do("SELECT", db); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	}
+
+	return newRedisCache(dial, prefix, d, logger), nil
+}
+
+// newRedisCache takes the dialer separately, so the cache can be pointed at
+// any server that speaks the protocol, e.g. one listening in-process.
+func newRedisCache(dial func() (net.Conn, error), prefix string, d time.Duration, logger *zap.Logger) *redisCache {
+	return &redisCache{
+		dial:       dial,
+		prefix:     prefix,
+		pool:       make(chan *redisConn, RedisPoolSize),
+		logger:     logger,
+		expiration: d,
+		linger:     DefaultEvictionInterval,
+	}
+}
+
+func (cache *redisCache) Get(k string) (interface{}, bool) {
+	v, e, f := cache.get(k)
+	if !f || (e != NoExpiration && time.Now().UnixNano() > e) {
+		atomic.AddUint64(&cache.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&cache.hits, 1)
+
+	return v, true
+}
+
+func (cache *redisCache) GetStale(k string) (interface{}, bool) {
+	v, _, f := cache.get(k)
+
+	return v, f
+}
+
+func (cache *redisCache) get(k string) (interface{}, int64, bool) {
+	r, err := cache.do("GET", cache.prefix+k)
+	if err == errRedisNil {
+		return nil, 0, false
+	}
+
+	if err != nil {
+		cache.logger.Warn("cannot read cache", zap.String("key", k), zap.Error(err))
+		return nil, 0, false
+	}
+
+	b, ok := r.([]byte)
+	if !ok {
+		return nil, 0, false
+	}
+
+	var e redisEnvelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		cache.logger.Warn("cannot decode cache entry", zap.String("key", k), zap.Error(err))
+		return nil, 0, false
+	}
+
+	t, ok := cacheTypes[e.Type]
+	if !ok {
+		return nil, 0, false
+	}
+
+	v := reflect.New(t)
+	if err := json.Unmarshal(e.Value, v.Interface()); err != nil {
+		cache.logger.Warn("cannot decode cache entry", zap.String("key", k), zap.Error(err))
+		return nil, 0, false
+	}
+
+	return v.Elem().Interface(), e.Expires, true
+}
+
+func (cache *redisCache) Set(k string, v interface{}) {
+	cache.SetWithTime(k, v, cache.expiration)
+}
+
+// SetWithTime stores a value that expires after d, a d of zero or less
+// keeps it until it is deleted. Values of types missing from cacheTypes
+// are not stored.
+func (cache *redisCache) SetWithTime(k string, v interface{}, d time.Duration) {
+	// Drops a configuration an earlier version may have stored.
+	if _, ok := v.(Configuration); ok {
+		cache.Delete(k)
+		return
+	}
+
+	name, ok := cacheTypeName(v)
+	if !ok {
+		cache.logger.Warn("cannot cache value", zap.String("key", k), zap.String("type", fmt.Sprintf("%T", v)))
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		cache.logger.Warn("cannot encode cache entry", zap.String("key", k), zap.Error(err))
+		return
+	}
+
+	var e = redisEnvelope{
+		Type:    name,
+		Expires: NoExpiration,
+		Value:   b,
+	}
+
+	args := []string{"SET", cache.prefix + k, ""}
+	if d > 0 {
+		e.Expires = time.Now().Add(d).UnixNano()
+		args = append(args, "PX", strconv.FormatInt(int64((d+cache.linger)/time.Millisecond), 10))
+	}
+
+	if b, err = json.Marshal(e); err == nil {
+		args[2] = string(b)
+		_, err = cache.do(args...)
+	}
+
+	if err != nil {
+		cache.logger.Warn("cannot write cache", zap.String("key", k), zap.Error(err))
+	}
+}
+
+func (cache *redisCache) Delete(k string) {
+	if _, err := cache.do("DEL", cache.prefix+k); err != nil {
+		cache.logger.Warn("cannot delete from cache", zap.String("key", k), zap.Error(err))
+	}
+}
+
+// DeleteExpired is left to Redis.
+func (cache *redisCache) DeleteExpired() {}
+
+// Clear removes every key under the prefix, other data on the server is
+// left alone.
+func (cache *redisCache) Clear() {
+	var cursor = "0"
+	for {
+		r, err := cache.do("SCAN", cursor, "MATCH", cache.prefix+"*", "COUNT", "100")
+		if err != nil {
+			cache.logger.Warn("cannot clear cache", zap.Error(err))
+			return
+		}
+
+		page, ok := r.([]interface{})
+		if !ok || len(page) != 2 {
+			return
+		}
+
+		next, _ := page[0].([]byte)
+		keys, _ := page[1].([]interface{})
+
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+
+			if _, err := cache.do(args...); err != nil {
+				cache.logger.Warn("cannot clear cache", zap.Error(err))
+				return
+			}
+		}
+
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// Stats only counts the lookups of this instance, entries are shared.
+func (cache *redisCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&cache.hits),
+		Misses: atomic.LoadUint64(&cache.misses),
+	}
+}
+
+// Finalize closes the connections but keeps the entries, other instances
+// may still use them.
+func (cache *redisCache) Finalize() {
+	for {
+		select {
+		case c := <-cache.pool:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (cache *redisCache) do(args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-cache.pool:
+	default:
+		conn, err := cache.dial()
+		if err != nil {
+			return nil, err
+		}
+
+		c = newRedisConn(conn)
+	}
+
+	r, err := c.do(args...)
+	if err != nil && err != errRedisNil {
+		// The connection may be out of step with the server, drop it
+		// unless the server merely answered with an error.
+		if _, ok := err.(redisError); !ok {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	select {
+	case cache.pool <- c:
+	default:
+		c.Close()
+	}
+
+	return r, err
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisConn speaks RESP, the Redis serialization protocol.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newRedisConn(conn net.Conn) *redisConn {
+	return &redisConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(RedisTimeout))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errRedisProtocol
+	}
+
+	t, line := line[0], line[1:len(line)-2]
+
+	switch t {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+
+		if n < 0 {
+			return nil, errRedisNil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+
+		if n < 0 {
+			return nil, errRedisNil
+		}
+
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := c.read()
+			if err != nil && err != errRedisNil {
+				return nil, err
+			}
+
+			items = append(items, v)
+		}
+
+		return items, nil
+	}
+
+	return nil, errRedisProtocol
+}
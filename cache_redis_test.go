@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// redisStub is an in-process server speaking just enough RESP for the
+// cache: GET, SET with PX, DEL and SCAN with MATCH and COUNT.
+type redisStub struct {
+	l net.Listener
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+
+	// cursors maps a SCAN cursor to the key it resumes at, so keys deleted
+	// between calls do not make it skip others.
+	cursors map[int]string
+	cursor  int
+}
+
+func newRedisStub(t *testing.T) *redisStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &redisStub{
+		l:       l,
+		values:  make(map[string]string),
+		expires: make(map[string]time.Time),
+		cursors: make(map[int]string),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+func (s *redisStub) dial() (net.Conn, error) {
+	return net.Dial("tcp", s.l.Addr().String())
+}
+
+func (s *redisStub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+
+		if _, err := io.WriteString(conn, s.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return nil, errRedisProtocol
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, errRedisProtocol
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(buf[:l]))
+	}
+
+	return args, nil
+}
+
+func (s *redisStub) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, e := range s.expires {
+		if !time.Now().Before(e) {
+			delete(s.values, k)
+			delete(s.expires, k)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		v, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		s.values[args[1]] = args[2]
+		delete(s.expires, args[1])
+
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil {
+				return "-ERR value is not an integer\r\n"
+			}
+
+			s.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+
+		return "+OK\r\n"
+	case "DEL":
+		var n int
+		for _, k := range args[1:] {
+			if _, ok := s.values[k]; ok {
+				delete(s.values, k)
+				delete(s.expires, k)
+				n++
+			}
+		}
+
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SCAN":
+		return s.scan(args)
+	}
+
+	return "-ERR unknown command\r\n"
+}
+
+// scan pages through the keys in order.
+func (s *redisStub) scan(args []string) string {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "-ERR invalid cursor\r\n"
+	}
+
+	from, ok := s.cursors[cursor]
+	if cursor != 0 && !ok {
+		return "-ERR invalid cursor\r\n"
+	}
+	delete(s.cursors, cursor)
+
+	var (
+		match = "*"
+		count = 10
+	)
+
+	for i := 2; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+		}
+	}
+
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		page []string
+		next = 0
+	)
+
+	for i := sort.SearchStrings(keys, from); i < len(keys); i++ {
+		if len(page) == count {
+			s.cursor++
+			next = s.cursor
+			s.cursors[next] = keys[i]
+			break
+		}
+
+		if ok, _ := path.Match(match, keys[i]); ok {
+			page = append(page, keys[i])
+		}
+	}
+
+	var b strings.Builder
+	c := strconv.Itoa(next)
+	fmt.Fprintf(&b, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(c), c, len(page))
+	for _, k := range page {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(k), k)
+	}
+
+	return b.String()
+}
+
+func (s *redisStub) has(k string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.values[k]
+	return ok
+}
+
+func newTestRedisCache(t *testing.T) (*redisCache, *redisStub) {
+	s := newRedisStub(t)
+	c := newRedisCache(s.dial, RedisCachePrefix, time.Minute, zap.NewNop())
+	t.Cleanup(c.Finalize)
+
+	return c, s
+}
+
+func TestRedisCacheRoundTrip(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	var (
+		published = time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+		project   = Project{Slug: "alpha", Title: "Alpha", Status: StatusPublished, Published: published}
+		content   = Content{Slug: "about", Title: "About", Paragraphs: []Paragraph{{Slug: "intro", Title: "Intro"}}}
+	)
+
+	values := map[string]interface{}{
+		"string":   "etag",
+		"user":     User{Name: "Jane", Title: "Designer", Joined: published},
+		"project":  project,
+		"projects": []Project{project, {Slug: "beta", Title: "Beta"}},
+		"content":  content,
+		"contents": []Content{content},
+		"menu":     Menu{0: {Slug: "about", Title: "About"}},
+		"routes":   map[string]Route{"about": {Slug: "about", Title: "About"}},
+	}
+
+	if len(values) != len(cacheTypes) {
+		t.Fatalf("%d values for %d cache types", len(values), len(cacheTypes))
+	}
+
+	for name, v := range values {
+		if reflect.TypeOf(v) != cacheTypes[name] {
+			t.Fatalf("%s: value is a %T", name, v)
+		}
+
+		c.Set(name, v)
+
+		got, ok := c.Get(name)
+		if !ok {
+			t.Errorf("%s: miss after set", name)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, v) {
+			t.Errorf("%s: got %#v, want %#v", name, got, v)
+		}
+	}
+
+	c.Delete("project")
+
+	if _, ok := c.Get("project"); ok {
+		t.Error("project: hit after delete")
+	}
+}
+
+func TestRedisCacheSkipsUnknownTypes(t *testing.T) {
+	c, s := newTestRedisCache(t)
+
+	c.Set("n", 42)
+
+	if s.has(RedisCachePrefix + "n") {
+		t.Error("value of an unknown type was stored")
+	}
+}
+
+func TestRedisCacheSkipsConfiguration(t *testing.T) {
+	c, s := newTestRedisCache(t)
+
+	c.Set("configuration", Configuration{JwtSecret: "secret"})
+
+	if s.has(RedisCachePrefix + "configuration") {
+		t.Error("configuration was stored")
+	}
+}
+
+func TestRedisCacheExpiry(t *testing.T) {
+	c, s := newTestRedisCache(t)
+	c.linger = 0
+
+	c.SetWithTime("k", "v", 50*time.Millisecond)
+	c.SetWithTime("kept", "v", 0)
+
+	if _, ok := c.Get("k"); !ok {
+		t.Fatal("miss before expiry")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Error("hit after expiry")
+	}
+
+	if _, ok := c.GetStale("k"); ok {
+		t.Error("stale hit after the server dropped the key")
+	}
+
+	if s.has(RedisCachePrefix + "k") {
+		t.Error("server kept the key past its TTL")
+	}
+
+	if _, ok := c.Get("kept"); !ok {
+		t.Error("value without expiration expired")
+	}
+}
+
+func TestRedisCacheStaleRead(t *testing.T) {
+	c, _ := newTestRedisCache(t)
+
+	c.SetWithTime("k", "v", 50*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := c.Get("k"); ok {
+		t.Error("hit after expiry")
+	}
+
+	// The key lingers on the server for the eviction interval.
+	v, ok := c.GetStale("k")
+	if !ok || v != "v" {
+		t.Errorf("stale read got %v, %v", v, ok)
+	}
+
+	if st := c.Stats(); st.Hits != 0 || st.Misses != 1 {
+		t.Errorf("stats %+v, want 0 hits and 1 miss", st)
+	}
+}
+
+func TestRedisCacheClear(t *testing.T) {
+	c, s := newTestRedisCache(t)
+
+	// More keys than one SCAN page, so Clear has to follow the cursor.
+	for i := 0; i < 250; i++ {
+		c.Set(fmt.Sprintf("key-%03d", i), "v")
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newRedisConn(conn)
+	defer other.Close()
+
+	if _, err := other.do("SET", "other:key", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Clear()
+
+	for i := 0; i < 250; i++ {
+		if k := fmt.Sprintf("key-%03d", i); s.has(RedisCachePrefix + k) {
+			t.Fatalf("%s survived Clear", k)
+		}
+	}
+
+	if !s.has("other:key") {
+		t.Error("Clear removed a key outside the prefix")
+	}
+}
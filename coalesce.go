@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type flight struct {
+	wg  sync.WaitGroup
+	v   interface{}
+	err error
+}
+
+// cacheLoader fills cache misses. Concurrent misses on one key share a
+// single load instead of each reading and decoding the same record. In
+// stale mode an expired value that has not been swept yet is returned at
+// once, while one goroutine loads its replacement.
+//
+// Writes to the cache go through the loader as well. Each one bumps the
+// generation of its key, and a load that started before it does not store
+// what it read, so an older value never replaces a newer one.
+type cacheLoader struct {
+	sync.Mutex
+	Cache
+
+	stale bool
+	calls map[string]*flight
+	gens  map[string]uint64
+	epoch uint64
+}
+
+func newCacheLoader(cache Cache, stale bool) *cacheLoader {
+	return &cacheLoader{
+		Cache: cache,
+		stale: stale,
+		calls: make(map[string]*flight),
+		gens:  make(map[string]uint64),
+	}
+}
+
+// Load returns the cached value of key, or the result of fn, which it
+// stores unless key was written to in the meantime.
+func (l *cacheLoader) Load(key string, fn func() (interface{}, error)) (interface{}, error) {
+	if v, f := l.Cache.Get(key); f {
+		return v, nil
+	}
+
+	if l.stale {
+		if v, f := l.Cache.GetStale(key); f {
+			l.refresh(key, fn)
+			return v, nil
+		}
+	}
+
+	return l.do(key, fn)
+}
+
+func (l *cacheLoader) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	l.Lock()
+	if c, ok := l.calls[key]; ok {
+		l.Unlock()
+		c.wg.Wait()
+		return c.v, c.err
+	}
+
+	c := new(flight)
+	c.wg.Add(1)
+	l.calls[key] = c
+	gen, epoch := l.gens[key], l.epoch
+	l.Unlock()
+
+	c.v, c.err = fn()
+	c.wg.Done()
+
+	l.Lock()
+	delete(l.calls, key)
+	// Storing under the lock keeps a write from bumping the generation
+	// between the check and the store.
+	if c.err == nil && l.gens[key] == gen && l.epoch == epoch {
+		l.Cache.Set(key, c.v)
+	}
+	l.Unlock()
+
+	return c.v, c.err
+}
+
+func (l *cacheLoader) refresh(key string, fn func() (interface{}, error)) {
+	l.Lock()
+	_, busy := l.calls[key]
+	l.Unlock()
+
+	if !busy {
+		go l.do(key, fn)
+	}
+}
+
+func (l *cacheLoader) bump(key string) {
+	l.Lock()
+	l.gens[key]++
+	l.Unlock()
+}
+
+func (l *cacheLoader) Set(k string, v interface{}) {
+	l.bump(k)
+	l.Cache.Set(k, v)
+}
+
+func (l *cacheLoader) SetWithTime(k string, v interface{}, d time.Duration) {
+	l.bump(k)
+	l.Cache.SetWithTime(k, v, d)
+}
+
+func (l *cacheLoader) Delete(k string) {
+	l.bump(k)
+	l.Cache.Delete(k)
+}
+
+func (l *cacheLoader) Clear() {
+	l.Lock()
+	l.epoch++
+	l.Unlock()
+
+	l.Cache.Clear()
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -93,7 +94,41 @@ func (c *Composer) getPageElements() (User, Menu, Meta, map[string]Route) {
 		close(c3)
 	}()
 
-	return <-c0, <-c1, <-c2, <-c3
+	u, m, t, r := <-c0, <-c1, <-c2, <-c3
+
+	hidden := c.getHiddenContents()
+	if len(hidden) == 0 {
+		return u, m, t, r
+	}
+
+	var (
+		m_ = make(Menu, len(m))
+		r_ = make(map[string]Route, len(r))
+	)
+
+	for k, v := range m {
+		if !hidden[v.Slug] {
+			m_[k] = v
+		}
+	}
+	for k, v := range r {
+		if !hidden[k] {
+			r_[k] = v
+		}
+	}
+
+	return u, m_, t, r_
+}
+
+// getHiddenContents returns the slugs of pages that are drafts or not
+// yet due, their routes and menu entries are not shown to visitors.
+func (c *Composer) getHiddenContents() map[string]bool {
+	cs, err := c.db.GetContents(context.TODO())
+	if err != nil {
+		return map[string]bool{}
+	}
+
+	return hiddenContents(cs, time.Now())
 }
 
 func (c *Composer) getUser() User {
@@ -116,6 +151,9 @@ func (c *Composer) GetHomePage() Page {
 		return Page{}
 	}
 
+	ps = publicProjects(ps, time.Now())
+	sortProjects(ps, c.configuration.ProjectOrder)
+
 	return Page{
 		Title: r["home"].Title,
 
@@ -176,7 +214,7 @@ func (c *Composer) GetProject(slug string) Page {
 	)
 
 	project, err := c.db.GetProject(ctx, slug)
-	if err != nil {
+	if err != nil || !project.Public(time.Now()) {
 		return Page{
 			Title: "Not found",
 
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CorsMethods string        = "GET, POST, PUT, DELETE"
+	CorsHeaders string        = "Content-Type, Authorization, X-Requested-With"
+	CorsMaxAge  time.Duration = 10 * time.Minute
+
+	// CorsAnyOrigin allows every origin. It cannot be combined with
+	// credentials.
+	CorsAnyOrigin string = "*"
+)
+
+// CorsPolicy decides which other origins may call the admin API from a
+// browser. The dashboard itself is served from the same origin and needs
+// none, so by default every other origin is refused.
+type CorsPolicy struct {
+	// Origins are matched exactly, or as patterns such as
+	// https://*.example.com.
+	Origins []string
+
+	Methods     []string
+	Headers     []string
+	Credentials bool
+	MaxAge      time.Duration
+}
+
+// NewCorsPolicy builds a policy from comma separated lists of origins,
+// methods and headers.
+func NewCorsPolicy(origins string, methods string, headers string, credentials bool, maxAge time.Duration) (CorsPolicy, error) {
+	p := CorsPolicy{
+		Origins:     splitList(origins),
+		Methods:     splitList(strings.ToUpper(methods)),
+		Headers:     splitList(headers),
+		Credentials: credentials,
+		MaxAge:      maxAge,
+	}
+
+	for _, o := range p.Origins {
+		if _, err := path.Match(o, ""); err != nil {
+			return p, ErrInvalidCorsOrigin
+		}
+
+		if o == CorsAnyOrigin && credentials {
+			return p, ErrCorsCredentials
+		}
+	}
+
+	return p, nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+// sameOrigin reports whether a request comes from the host serving it,
+// which browsers also tell with an Origin header.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+func (p CorsPolicy) allowsOrigin(origin string) bool {
+	for _, o := range p.Origins {
+		if ok, _ := path.Match(o, origin); ok || o == CorsAnyOrigin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p CorsPolicy) allowsMethod(method string) bool {
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsHeaders reports whether every header of a preflight's
+// Access-Control-Request-Headers may be sent.
+func (p CorsPolicy) allowsHeaders(requested string) bool {
+	for _, h := range splitList(requested) {
+		var ok bool
+		for _, v := range p.Headers {
+			if strings.EqualFold(h, v) {
+				ok = true
+				break
+			}
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allow sets the headers every answer to an allowed origin carries. The
+// origin is echoed rather than answered with *, so credentials work.
+func (p CorsPolicy) allow(w http.ResponseWriter, origin string) {
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate")
+
+	if p.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p CorsPolicy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !p.allowsMethod(r.Header.Get("Access-Control-Request-Method")) || !p.allowsHeaders(r.Header.Get("Access-Control-Request-Headers")) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	p.allow(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.Methods, ", "))
+
+	if len(p.Headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "))
+	}
+
+	if p.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -32,7 +32,6 @@ type DB interface {
 	GetProject(ctx context.Context, slug string) (Project, error)
 	GetMenu(ctx context.Context) (Menu, error)
 	GetConfiguration(ctx context.Context) (Configuration, error)
-	GetCredentials(ctx context.Context) (Credentials, error)
 
 	// Create
 	CreateContent(content *Content) error
@@ -43,7 +42,6 @@ type DB interface {
 	PutContent(content *Content) error
 	PutProject(project *Project) error
 	PutMenu(menu *Menu) error
-	PutCredentials(credentials *Credentials) error
 	PutConfiguration(configutation *Configuration) error
 	PutRoute(route *Route) error
 
@@ -51,325 +49,360 @@ type DB interface {
 	DeleteContent(slug string) error
 	DeleteProject(slug string) error
 
+	// History
+	GetRevisions(ctx context.Context, kind RevisionKind, slug string) ([]Revision, error)
+	GetRevision(ctx context.Context, kind RevisionKind, slug string, id uint64) (Revision, error)
+	PutRevision(revision *Revision) error
+
+	// Accounts
+	GetAccounts(ctx context.Context) ([]Account, error)
+	GetAccount(ctx context.Context, id string) (Account, error)
+	GetAccountByEmail(ctx context.Context, email string) (Account, error)
+	PutAccount(account *Account) error
+	UpdateAccount(id string, fn func(a *Account) error) (Account, error)
+	DeleteAccount(id string) error
+	ReplaceAccounts(accounts []Account) error
+
+	// Sessions
+	GetSessions(ctx context.Context, account string) ([]Session, error)
+	GetSession(ctx context.Context, id string) (Session, error)
+	PutSession(session *Session) error
+	TouchSession(id string, t time.Time) error
+	RotateSession(id string, generation uint64, t time.Time) (Session, error)
+	DeleteSession(id string) error
+	DeleteSessions(account string, except string) error
+
+	// API keys
+	GetAPIKeys(ctx context.Context) ([]APIKey, error)
+	GetAPIKey(ctx context.Context, id string) (APIKey, error)
+	PutAPIKey(key *APIKey) error
+	TouchAPIKey(id string, t time.Time) error
+	DeleteAPIKey(id string) error
+
+	// Password resets
+	GetPasswordReset(ctx context.Context, account string) (PasswordReset, error)
+	PutPasswordReset(reset *PasswordReset) error
+	UsePasswordReset(account string, hash string, now time.Time) error
+	DeletePasswordReset(account string) error
+
+	// Audit log
+	GetAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+	PutAuditEntry(e *AuditEntry) error
+	DeleteAuditEntriesBefore(t time.Time) (int, error)
+
+	// Login attempts
+	GetLoginAttempts(ctx context.Context, key string) (LoginAttempts, error)
+	UpdateLoginAttempts(key string, fn func(a *LoginAttempts) error) (LoginAttempts, error)
+	DeleteLoginAttempts(key string) error
+	PruneLoginAttempts(expired func(a LoginAttempts) bool) (int, error)
+	ClearLoginAttempts() error
+
+	// Redirects
+	GetRedirect(ctx context.Context, kind RevisionKind, slug string) (Redirect, error)
+	GetRedirects(ctx context.Context) ([]Redirect, error)
+	PutRedirect(redirect *Redirect) error
+	RenameProject(from string, to string) error
+	RenameContent(from string, to string) error
+
+	// Order
+	ReorderProjects(slugs []string) error
+
+	// Trash
+	GetTrash(ctx context.Context) ([]TrashItem, error)
+	RestoreTrashItem(id uint64) (TrashItem, error)
+	PurgeTrashItem(id uint64) (TrashItem, error)
+	PutTrashItem(item *TrashItem) error
+
+	// Unit of work
+	Atomic(fn func(tx Tx) error) error
+
 	// Config
 	Setup(context.Context) (Configuration, error)
 }
 
+type DatabaseOptions struct {
+	// HistoryLimit caps the number of revisions kept per item, 0 keeps
+	// every revision.
+	HistoryLimit int
+
+	// StaleWhileRevalidate serves expired cache entries while a single
+	// load refreshes them.
+	StaleWhileRevalidate bool
+}
+
 type cachedDatabase struct {
-	cache  Cache
-	bolt   *bolt.DB
-	logger *zap.Logger
+	cache   Cache
+	loader  *cacheLoader
+	bolt    *bolt.DB
+	logger  *zap.Logger
+	options DatabaseOptions
 }
 
-func NewCachedDatabase(bolt *bolt.DB, cache Cache, logger *zap.Logger) DB {
+func NewCachedDatabase(bolt *bolt.DB, cache Cache, logger *zap.Logger, options DatabaseOptions) DB {
+	loader := newCacheLoader(cache, options.StaleWhileRevalidate)
+
 	return &cachedDatabase{
-		cache:  cache,
-		bolt:   bolt,
-		logger: logger,
+		cache:   loader,
+		loader:  loader,
+		bolt:    bolt,
+		logger:  logger,
+		options: options,
 	}
 }
 
 func (db *cachedDatabase) GetUser(ctx context.Context) (User, error) {
-	user, f := db.cache.Get("user")
-	if f {
-		return user.(User), nil
-	}
+	user, err := db.loader.Load("user", func() (interface{}, error) {
+		var u User
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_COMMON))
+			v := b.Get([]byte("user"))
 
-	var u User
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		v := b.Get([]byte("user"))
+			err := json.Unmarshal(v, &u)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
 
-		err := json.Unmarshal(v, &u)
 		if err != nil {
-			return err
+			db.logger.Error("cannot get user", zap.Error(err))
+			return nil, ErrDatabase
 		}
-		return nil
+
+		return u, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get user", zap.Error(err))
-		return User{}, ErrDatabase
+		return User{}, err
 	}
 
-	db.cache.Set("user", u)
-
-	return u, nil
+	return user.(User), nil
 }
 
 func (db *cachedDatabase) GetRoutes(ctx context.Context) (map[string]Route, error) {
-	routes, f := db.cache.Get("routes")
-	if f {
-		return routes.(map[string]Route), nil
-	}
-
-	var r map[string]Route = make(map[string]Route)
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(BUCKET_ROUTES)).Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var o Route
-			err := json.Unmarshal(v, &o)
-			if err != nil {
-				return err
+	routes, err := db.loader.Load("routes", func() (interface{}, error) {
+		var r map[string]Route = make(map[string]Route)
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(BUCKET_ROUTES)).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var o Route
+				err := json.Unmarshal(v, &o)
+				if err != nil {
+					return err
+				}
+				r[o.Slug] = o
 			}
-			r[o.Slug] = o
+
+			return nil
+		})
+
+		if err != nil {
+			db.logger.Error("cannot get routes", zap.Error(err))
+			return nil, ErrDatabase
 		}
 
-		return nil
+		return r, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get routes", zap.Error(err))
-		return r, ErrDatabase
+		return map[string]Route{}, err
 	}
 
-	db.cache.Set("routes", r)
-
-	return r, nil
+	return routes.(map[string]Route), nil
 }
 
 func (db *cachedDatabase) GetProjects(ctx context.Context) ([]Project, error) {
-	projects, f := db.cache.Get("projects")
-	if f {
-		return projects.([]Project), nil
-	}
-
-	var ps []Project
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(BUCKET_PROJECTS)).Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var p Project
-			err := json.Unmarshal(v, &p)
-			if err != nil {
-				return err
+	projects, err := db.loader.Load("projects", func() (interface{}, error) {
+		var ps []Project
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(BUCKET_PROJECTS)).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var p Project
+				err := json.Unmarshal(v, &p)
+				if err != nil {
+					return err
+				}
+				ps = append(ps, p)
 			}
-			ps = append(ps, p)
+
+			return nil
+		})
+
+		if err != nil {
+			db.logger.Error("cannot get projects", zap.Error(err))
+			return nil, ErrDatabase
 		}
 
-		return nil
+		sortProjects(ps, ProjectOrderManual)
+
+		return ps, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get projects", zap.Error(err))
-		return []Project{}, ErrDatabase
-	}
-
-	if len(ps) > 0 {
-		db.cache.Set("projects", ps)
+		return []Project{}, err
 	}
 
-	return ps, nil
+	return projects.([]Project), nil
 }
 
 func (db *cachedDatabase) GetProject(ctx context.Context, Slug string) (Project, error) {
-	project, f := db.cache.Get(fmt.Sprintf("project-%s", Slug))
-	if f {
-		return project.(Project), nil
-	}
+	project, err := db.loader.Load(fmt.Sprintf("project-%s", Slug), func() (interface{}, error) {
+		var p Project
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_PROJECTS))
+			v := b.Get([]byte(Slug))
 
-	var p Project
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_PROJECTS))
-		v := b.Get([]byte(Slug))
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
 
-		err := json.Unmarshal(v, &p)
 		if err != nil {
-			return err
+			db.logger.Error("cannot get project", zap.Error(err))
+			return nil, ErrDatabase
 		}
-		return nil
+
+		return p, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get project", zap.Error(err))
-		return Project{}, ErrDatabase
+		return Project{}, err
 	}
 
-	db.cache.Set(fmt.Sprintf("project-%s", Slug), p)
-
-	return p, nil
+	return project.(Project), nil
 }
 
 func (db *cachedDatabase) GetMenu(ctx context.Context) (Menu, error) {
-	menu, f := db.cache.Get("menu")
-	if f {
-		return menu.(Menu), nil
-	}
+	menu, err := db.loader.Load("menu", func() (interface{}, error) {
+		var m Menu
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_COMMON))
+			v := b.Get([]byte("menu"))
 
-	var m Menu
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		v := b.Get([]byte("menu"))
+			err := json.Unmarshal(v, &m)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
 
-		err := json.Unmarshal(v, &m)
 		if err != nil {
-			return err
+			db.logger.Error("cannot get menu", zap.Error(err))
+			return nil, ErrDatabase
 		}
-		return nil
+
+		return m, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get menu", zap.Error(err))
-		return Menu{}, ErrDatabase
+		return Menu{}, err
 	}
 
-	db.cache.Set("menu", m)
-
-	return m, nil
+	return menu.(Menu), nil
 }
 
 func (db *cachedDatabase) GetContents(ctx context.Context) ([]Content, error) {
-	contents, f := db.cache.Get("contents")
-	if f {
-		return contents.([]Content), nil
-	}
-
-	var cs []Content
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte(BUCKET_CONTENT)).Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var co Content
-			err := json.Unmarshal(v, &co)
-			if err != nil {
-				return err
+	contents, err := db.loader.Load("contents", func() (interface{}, error) {
+		var cs []Content
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket([]byte(BUCKET_CONTENT)).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var co Content
+				err := json.Unmarshal(v, &co)
+				if err != nil {
+					return err
+				}
+				cs = append(cs, co)
 			}
-			cs = append(cs, co)
+
+			return nil
+		})
+
+		if err != nil {
+			db.logger.Error("cannot get contents", zap.Error(err))
+			return nil, ErrDatabase
 		}
 
-		return nil
+		return cs, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get contents", zap.Error(err))
-		return []Content{}, ErrDatabase
+		return []Content{}, err
 	}
 
-	if len(cs) > 0 {
-		db.cache.Set("contents", cs)
-	}
-
-	return cs, nil
+	return contents.([]Content), nil
 }
 
 func (db *cachedDatabase) GetContent(ctx context.Context, Slug string) (Content, error) {
-	content, f := db.cache.Get(fmt.Sprintf("content-%s", Slug))
-	if f {
-		return content.(Content), nil
-	}
+	content, err := db.loader.Load(fmt.Sprintf("content-%s", Slug), func() (interface{}, error) {
+		var c Content
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_CONTENT))
+			v := b.Get([]byte(Slug))
 
-	var c Content
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_CONTENT))
-		v := b.Get([]byte(Slug))
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
 
-		err := json.Unmarshal(v, &c)
 		if err != nil {
-			return err
+			db.logger.Error("cannot get content", zap.Error(err))
+			return nil, ErrDatabase
 		}
-		return nil
+
+		return c, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get content", zap.Error(err))
-		return Content{}, ErrDatabase
+		return Content{}, err
 	}
 
-	db.cache.Set(fmt.Sprintf("content-%s", Slug), c)
-
-	return c, nil
+	return content.(Content), nil
 }
 
 func (db *cachedDatabase) GetConfiguration(ctx context.Context) (Configuration, error) {
-	configuration, f := db.cache.Get("configuration")
-	if f {
-		return configuration.(Configuration), nil
-	}
+	configuration, err := db.loader.Load("configuration", func() (interface{}, error) {
+		var c Configuration
+		err := db.bolt.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_COMMON))
+			v := b.Get([]byte("configuration"))
 
-	var c Configuration
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		v := b.Get([]byte("configuration"))
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
 
-		err := json.Unmarshal(v, &c)
 		if err != nil {
-			return err
+			db.logger.Error("cannot get configuration", zap.Error(err))
+			return nil, ErrDatabase
 		}
-		return nil
-	})
 
-	if err != nil {
-		db.logger.Error("cannot get configuration", zap.Error(err))
-		return Configuration{}, ErrDatabase
-	}
-
-	db.cache.Set("configuration", c)
-
-	return c, nil
-}
-
-func (db *cachedDatabase) GetCredentials(ctx context.Context) (Credentials, error) {
-	credentials, f := db.cache.Get("credentials")
-	if f {
-		return credentials.(Credentials), nil
-	}
-
-	var c Credentials
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		v := b.Get([]byte("credentials"))
-
-		err := json.Unmarshal(v, &c)
-		if err != nil {
-			return err
-		}
-		return nil
+		return c, nil
 	})
 
 	if err != nil {
-		db.logger.Error("cannot get credentials", zap.Error(err))
-		return Credentials{}, ErrDatabase
+		return Configuration{}, err
 	}
 
-	db.cache.Set("credentials", c)
-
-	return c, nil
+	return configuration.(Configuration), nil
 }
 
 func (db *cachedDatabase) CreateContent(content *Content) error {
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		v := b.Get([]byte(content.Slug))
-
-		if v != nil {
-			return ErrContentExists
-		}
-
-		return nil
+	return db.Atomic(func(tx Tx) error {
+		return tx.CreateContent(content)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	return db.PutContent(content)
 }
 
 func (db *cachedDatabase) CreateProject(project *Project) error {
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_PROJECTS))
-		v := b.Get([]byte(project.Slug))
-
-		if v != nil {
-			return ErrProjectExists
-		}
-
-		return nil
+	return db.Atomic(func(tx Tx) error {
+		return tx.CreateProject(project)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	return db.PutProject(project)
 }
 
 func (db *cachedDatabase) PutUser(user *User) error {
@@ -388,109 +421,21 @@ func (db *cachedDatabase) PutUser(user *User) error {
 }
 
 func (db *cachedDatabase) PutContent(content *Content) error {
-	if content.Slug == "" {
-		return ErrNoSlug
-	}
-
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_CONTENT))
-		return save(b, []byte(content.Slug), content)
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutContent(content)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	m, err := db.GetMenu(context.TODO())
-	if err != nil {
-		return err
-	}
-
-	for i, r := range m {
-		if r.Slug == content.Slug {
-			m[i] = Route{Title: content.Title, Slug: content.Slug}
-			break
-		}
-	}
-
-	err = db.PutMenu(&m)
-	if err != nil {
-		return err
-	}
-
-	db.cache.Delete("routes")
-	db.cache.Delete("contents")
-	db.cache.Set(fmt.Sprintf("content-%s", content.Slug), *content)
-
-	return nil
 }
 
 func (db *cachedDatabase) PutProject(project *Project) error {
-	if project.Slug == "" {
-		return ErrNoSlug
-	}
-
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_PROJECTS))
-		return save(b, []byte(project.Slug), project)
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutProject(project)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	m, err := db.GetMenu(context.TODO())
-	if err != nil {
-		return err
-	}
-
-	for i, r := range m {
-		if r.Slug == project.Slug {
-			m[i] = Route{Title: project.Title, Slug: project.Slug}
-			break
-		}
-	}
-
-	err = db.PutMenu(&m)
-	if err != nil {
-		return err
-	}
-
-	db.cache.Delete("routes")
-	db.cache.Delete("projects")
-	db.cache.Set(fmt.Sprintf("project-%s", project.Slug), *project)
-
-	return nil
 }
 
 func (db *cachedDatabase) PutMenu(menu *Menu) error {
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		return save(b, []byte("menu"), menu)
-	})
-
-	if err != nil {
-		return err
-	}
-
-	db.cache.Set("menu", *menu)
-
-	return nil
-}
-
-func (db *cachedDatabase) PutCredentials(credentials *Credentials) error {
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_COMMON))
-		return save(b, []byte("credentials"), credentials)
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutMenu(menu)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	db.cache.Set("credentials", *credentials)
-
-	return nil
 }
 
 func (db *cachedDatabase) PutConfiguration(configutation *Configuration) error {
@@ -509,50 +454,32 @@ func (db *cachedDatabase) PutConfiguration(configutation *Configuration) error {
 }
 
 func (db *cachedDatabase) PutRoute(route *Route) error {
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_ROUTES))
-		return save(b, []byte(route.Slug), route)
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutRoute(route)
 	})
-
-	if err != nil {
-		return err
-	}
-
-	db.cache.Delete("routes")
-
-	return nil
 }
 
+// DeleteProject moves a project into the trash.
 func (db *cachedDatabase) DeleteProject(slug string) error {
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_PROJECTS))
-		return b.Delete([]byte(slug))
-	})
-
+	err := db.trash(RevisionProject, BUCKET_PROJECTS, slug)
 	if err != nil {
 		return err
 	}
 
-	db.cache.Delete(fmt.Sprintf("project-%s", slug))
-	db.cache.Delete("projects")
-	db.cache.Delete("routes")
+	db.invalidateItem(RevisionProject, slug)
 
 	return nil
 }
 
+// DeleteContent moves a page into the trash, together with its route and
+// menu entry.
 func (db *cachedDatabase) DeleteContent(slug string) error {
-	err := db.bolt.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BUCKET_CONTENT))
-		return b.Delete([]byte(slug))
-	})
-
+	err := db.trash(RevisionContent, BUCKET_CONTENT, slug)
 	if err != nil {
 		return err
 	}
 
-	db.cache.Delete(fmt.Sprintf("content-%s", slug))
-	db.cache.Delete("contents")
-	db.cache.Delete("routes")
+	db.invalidateItem(RevisionContent, slug)
 
 	return nil
 }
@@ -584,6 +511,51 @@ func (db *cachedDatabase) Setup(ctx context.Context) (Configuration, error) {
 			return err
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_META))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_HISTORY))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_TRASH))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_ACCOUNTS))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_REDIRECTS))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_SESSIONS))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_APIKEYS))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_RESETS))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BUCKET_AUDIT))
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 
@@ -619,114 +591,31 @@ func (db *cachedDatabase) Setup(ctx context.Context) (Configuration, error) {
 			return nil
 		}
 
-		var (
-			t  string
-			ok bool
-		)
-		if _, ok = themes["peaches"]; ok {
-			t = "peaches"
-		} else {
-			k, _ := firstKey(themes)
-			t = string(k.(string))
-		}
-
-		u := uuid.New().String()
-
-		if u == "" {
-			return errors.New("cannot generate uuid for jwt")
-		}
-
-		c = Configuration{
-			CurrentThemePath: t,
-			CurrentTheme:     themes[t],
-			SetupCompleted:   false,
-			JwtSecret:        u,
-			Meta: Meta{
-				Title: "",
-				Site:  "",
-				Tags: map[string]string{
-					"description": "",
-					"keywords":    "",
-					"author":      "",
-					"viewport":    "width=device-width, initial-scale=1.0",
-				},
-				OGTags: map[string]string{
-					"title": "",
-					"type":  "website",
-					"url":   "",
-					"image": "",
-				},
-			},
-		}
-
-		err = save(b, []byte("configuration"), c)
+		c, err = defaultConfiguration(themes)
 		if err != nil {
 			return err
 		}
 
-		user := User{
-			Name:        "User",
-			Title:       "Title",
-			About:       "",
-			Image:       Media{},
-			Logo:        Media{},
-			Joined:      time.Now(),
-			References:  Map{},
-			Networks:    Map{},
-			Experiences: Map{},
-			Interests:   []Interest{},
-			Contact: Contact{
-				Country: "",
-				City:    "",
-				Street:  "",
-				Email:   "",
-				Phone:   "",
-			},
-		}
-
-		err = save(b, []byte("user"), user)
+		err = save(b, []byte("configuration"), c)
 		if err != nil {
 			return err
 		}
 
-		credentials := Credentials{}
-
-		err = save(b, []byte("credentials"), credentials)
+		err = save(b, []byte("user"), defaultUser())
 		if err != nil {
 			return err
 		}
 
 		b = tx.Bucket([]byte(BUCKET_ROUTES))
 
-		r1 := Route{
-			Title: "Home",
-			Slug:  "home",
-		}
-
-		save(b, []byte(r1.Slug), r1)
-
-		r2 := Route{
-			Title: "Contact",
-			Slug:  "contact",
-		}
-
-		save(b, []byte(r2.Slug), r2)
-
-		r3 := Route{
-			Title: "Not Found",
-			Slug:  "notfound",
+		routes := defaultRoutes()
+		for _, r := range routes {
+			save(b, []byte(r.Slug), r)
 		}
 
-		save(b, []byte(r3.Slug), r3)
-
 		b = tx.Bucket([]byte(BUCKET_COMMON))
 
-		menu := Menu{
-			0: r1,
-			1: r2,
-		}
-
-		err = save(b, []byte("menu"), menu)
+		err = save(b, []byte("menu"), defaultMenu(routes))
 		if err != nil {
 			return err
 		}
@@ -742,6 +631,95 @@ func (db *cachedDatabase) Setup(ctx context.Context) (Configuration, error) {
 	return c, nil
 }
 
+func defaultConfiguration(themes map[string]Theme) (Configuration, error) {
+	var (
+		t  string
+		ok bool
+	)
+	if _, ok = themes[DefaultTheme]; ok {
+		t = DefaultTheme
+	} else {
+		k, _ := firstKey(themes)
+		t = string(k.(string))
+	}
+
+	u := uuid.New().String()
+
+	if u == "" {
+		return Configuration{}, errors.New("cannot generate uuid for jwt")
+	}
+
+	return Configuration{
+		CurrentThemePath: t,
+		CurrentTheme:     themes[t],
+		SetupCompleted:   false,
+		JwtSecret:        u,
+		ProjectOrder:     ProjectOrderManual,
+		Meta: Meta{
+			Title: "",
+			Site:  "",
+			Tags: map[string]string{
+				"description": "",
+				"keywords":    "",
+				"author":      "",
+				"viewport":    "width=device-width, initial-scale=1.0",
+			},
+			OGTags: map[string]string{
+				"title": "",
+				"type":  "website",
+				"url":   "",
+				"image": "",
+			},
+		},
+	}, nil
+}
+
+func defaultUser() User {
+	return User{
+		Name:        "User",
+		Title:       "Title",
+		About:       "",
+		Image:       Media{},
+		Logo:        Media{},
+		Joined:      time.Now(),
+		References:  Map{},
+		Networks:    Map{},
+		Experiences: Map{},
+		Interests:   []Interest{},
+		Contact: Contact{
+			Country: "",
+			City:    "",
+			Street:  "",
+			Email:   "",
+			Phone:   "",
+		},
+	}
+}
+
+func defaultRoutes() []Route {
+	return []Route{
+		Route{
+			Title: "Home",
+			Slug:  "home",
+		},
+		Route{
+			Title: "Contact",
+			Slug:  "contact",
+		},
+		Route{
+			Title: "Not Found",
+			Slug:  "notfound",
+		},
+	}
+}
+
+func defaultMenu(routes []Route) Menu {
+	return Menu{
+		0: routes[0],
+		1: routes[1],
+	}
+}
+
 func save(b *bolt.Bucket, k []byte, v interface{}) error {
 	buf, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"go.uber.org/zap"
+)
+
+const (
+	SqliteDriver       string = "sqlite"
+	SqliteDatabasePath string = "database/showcase.sqlite"
+)
+
+var (
+	sqliteTables = []string{
+		`CREATE TABLE IF NOT EXISTS meta (
+			key   TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS common (
+			key   TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS themes (
+			path TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS projects (
+			slug      TEXT PRIMARY KEY,
+			title     TEXT NOT NULL,
+			subtitle  TEXT NOT NULL,
+			published TEXT NOT NULL,
+			data      TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS contents (
+			slug      TEXT PRIMARY KEY,
+			title     TEXT NOT NULL,
+			subtitle  TEXT NOT NULL,
+			published TEXT NOT NULL,
+			data      TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS routes (
+			slug  TEXT PRIMARY KEY,
+			title TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS menu (
+			position INTEGER PRIMARY KEY,
+			slug     TEXT NOT NULL,
+			title    TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS revisions (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind    TEXT NOT NULL,
+			slug    TEXT NOT NULL,
+			author  TEXT NOT NULL,
+			created TEXT NOT NULL,
+			data    TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS revisions_item ON revisions (kind, slug, id)`,
+		`CREATE TABLE IF NOT EXISTS trash (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind    TEXT NOT NULL,
+			slug    TEXT NOT NULL,
+			deleted TEXT NOT NULL,
+			data    TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS accounts (
+			id    TEXT PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			data  TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id      TEXT PRIMARY KEY,
+			account TEXT NOT NULL,
+			data    TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS sessions_account ON sessions (account)`,
+		`CREATE TABLE IF NOT EXISTS apikeys (
+			id   TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS resets (
+			account TEXT PRIMARY KEY,
+			data    TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS logins (
+			key  TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit (
+			id     INTEGER PRIMARY KEY AUTOINCREMENT,
+			time   INTEGER NOT NULL,
+			actor  TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			data   TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS audit_time ON audit (time)`,
+		`CREATE TABLE IF NOT EXISTS redirects (
+			kind      TEXT NOT NULL,
+			from_slug TEXT NOT NULL,
+			to_slug   TEXT NOT NULL,
+			created   TEXT NOT NULL,
+			PRIMARY KEY (kind, from_slug)
+		)`,
+	}
+)
+
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type sqliteDatabase struct {
+	cache   Cache
+	loader  *cacheLoader
+	sql     *sql.DB
+	logger  *zap.Logger
+	options DatabaseOptions
+}
+
+func NewSqliteDatabase(db *sql.DB, cache Cache, logger *zap.Logger, options DatabaseOptions) DB {
+	loader := newCacheLoader(cache, options.StaleWhileRevalidate)
+
+	return &sqliteDatabase{
+		cache:   loader,
+		loader:  loader,
+		sql:     db,
+		logger:  logger,
+		options: options,
+	}
+}
+
+func OpenSqlite(path string) (*sql.DB, error) {
+	db, err := sql.Open(SqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, serialise access instead of
+	// failing with SQLITE_BUSY under concurrent admin requests.
+	db.SetMaxOpenConns(1)
+
+	return db, nil
+}
+
+func (db *sqliteDatabase) GetUser(ctx context.Context) (User, error) {
+	user, err := db.loader.Load("user", func() (interface{}, error) {
+		var u User
+		err := db.getCommon(ctx, "user", &u)
+		if err != nil {
+			db.logger.Error("cannot get user", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return u, nil
+	})
+
+	if err != nil {
+		return User{}, err
+	}
+
+	return user.(User), nil
+}
+
+func (db *sqliteDatabase) GetRoutes(ctx context.Context) (map[string]Route, error) {
+	routes, err := db.loader.Load("routes", func() (interface{}, error) {
+		var r map[string]Route = make(map[string]Route)
+
+		rows, err := db.sql.QueryContext(ctx, `SELECT slug, title FROM routes ORDER BY slug`)
+		if err == nil {
+			defer rows.Close()
+
+			for rows.Next() {
+				var o Route
+				if err = rows.Scan(&o.Slug, &o.Title); err != nil {
+					break
+				}
+				r[o.Slug] = o
+			}
+
+			if err == nil {
+				err = rows.Err()
+			}
+		}
+
+		if err != nil {
+			db.logger.Error("cannot get routes", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return r, nil
+	})
+
+	if err != nil {
+		return map[string]Route{}, err
+	}
+
+	return routes.(map[string]Route), nil
+}
+
+func (db *sqliteDatabase) GetProjects(ctx context.Context) ([]Project, error) {
+	projects, err := db.loader.Load("projects", func() (interface{}, error) {
+		var ps []Project
+		err := db.queryRecords(ctx, `SELECT data FROM projects ORDER BY slug`, func(data []byte) error {
+			var p Project
+			if err := json.Unmarshal(data, &p); err != nil {
+				return err
+			}
+			ps = append(ps, p)
+			return nil
+		})
+
+		if err != nil {
+			db.logger.Error("cannot get projects", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		sortProjects(ps, ProjectOrderManual)
+
+		return ps, nil
+	})
+
+	if err != nil {
+		return []Project{}, err
+	}
+
+	return projects.([]Project), nil
+}
+
+func (db *sqliteDatabase) GetProject(ctx context.Context, Slug string) (Project, error) {
+	project, err := db.loader.Load(fmt.Sprintf("project-%s", Slug), func() (interface{}, error) {
+		var p Project
+		err := db.getRecord(ctx, `SELECT data FROM projects WHERE slug = ?`, Slug, &p)
+		if err != nil {
+			db.logger.Error("cannot get project", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return p, nil
+	})
+
+	if err != nil {
+		return Project{}, err
+	}
+
+	return project.(Project), nil
+}
+
+func (db *sqliteDatabase) GetMenu(ctx context.Context) (Menu, error) {
+	menu, err := db.loader.Load("menu", func() (interface{}, error) {
+		var m Menu = make(Menu)
+
+		rows, err := db.sql.QueryContext(ctx, `SELECT position, slug, title FROM menu ORDER BY position`)
+		if err == nil {
+			defer rows.Close()
+
+			for rows.Next() {
+				var (
+					i int
+					r Route
+				)
+				if err = rows.Scan(&i, &r.Slug, &r.Title); err != nil {
+					break
+				}
+				m[i] = r
+			}
+
+			if err == nil {
+				err = rows.Err()
+			}
+		}
+
+		if err != nil {
+			db.logger.Error("cannot get menu", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return m, nil
+	})
+
+	if err != nil {
+		return Menu{}, err
+	}
+
+	return menu.(Menu), nil
+}
+
+func (db *sqliteDatabase) GetContents(ctx context.Context) ([]Content, error) {
+	contents, err := db.loader.Load("contents", func() (interface{}, error) {
+		var cs []Content
+		err := db.queryRecords(ctx, `SELECT data FROM contents ORDER BY slug`, func(data []byte) error {
+			var c Content
+			if err := json.Unmarshal(data, &c); err != nil {
+				return err
+			}
+			cs = append(cs, c)
+			return nil
+		})
+
+		if err != nil {
+			db.logger.Error("cannot get contents", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return cs, nil
+	})
+
+	if err != nil {
+		return []Content{}, err
+	}
+
+	return contents.([]Content), nil
+}
+
+func (db *sqliteDatabase) GetContent(ctx context.Context, Slug string) (Content, error) {
+	content, err := db.loader.Load(fmt.Sprintf("content-%s", Slug), func() (interface{}, error) {
+		var c Content
+		err := db.getRecord(ctx, `SELECT data FROM contents WHERE slug = ?`, Slug, &c)
+		if err != nil {
+			db.logger.Error("cannot get content", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return c, nil
+	})
+
+	if err != nil {
+		return Content{}, err
+	}
+
+	return content.(Content), nil
+}
+
+func (db *sqliteDatabase) GetConfiguration(ctx context.Context) (Configuration, error) {
+	configuration, err := db.loader.Load("configuration", func() (interface{}, error) {
+		var c Configuration
+		err := db.getCommon(ctx, "configuration", &c)
+		if err != nil {
+			db.logger.Error("cannot get configuration", zap.Error(err))
+			return nil, ErrDatabase
+		}
+
+		return c, nil
+	})
+
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	return configuration.(Configuration), nil
+}
+
+func (db *sqliteDatabase) CreateContent(content *Content) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.CreateContent(content)
+	})
+}
+
+func (db *sqliteDatabase) CreateProject(project *Project) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.CreateProject(project)
+	})
+}
+
+func (db *sqliteDatabase) PutUser(user *User) error {
+	err := putCommon(db.sql, "user", user)
+	if err != nil {
+		return err
+	}
+
+	db.cache.Set("user", *user)
+
+	return nil
+}
+
+func (db *sqliteDatabase) PutContent(content *Content) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutContent(content)
+	})
+}
+
+func (db *sqliteDatabase) PutProject(project *Project) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutProject(project)
+	})
+}
+
+func (db *sqliteDatabase) PutMenu(menu *Menu) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutMenu(menu)
+	})
+}
+
+func (db *sqliteDatabase) PutConfiguration(configutation *Configuration) error {
+	err := putCommon(db.sql, "configuration", configutation)
+	if err != nil {
+		return err
+	}
+
+	db.cache.Set("configuration", *configutation)
+
+	return nil
+}
+
+func (db *sqliteDatabase) PutRoute(route *Route) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutRoute(route)
+	})
+}
+
+func (db *sqliteDatabase) DeleteProject(slug string) error {
+	err := db.trash(RevisionProject, "projects", slug)
+	if err != nil {
+		return err
+	}
+
+	db.invalidateItem(RevisionProject, slug)
+
+	return nil
+}
+
+func (db *sqliteDatabase) DeleteContent(slug string) error {
+	err := db.trash(RevisionContent, "contents", slug)
+	if err != nil {
+		return err
+	}
+
+	db.invalidateItem(RevisionContent, slug)
+
+	return nil
+}
+
+func (db *sqliteDatabase) Setup(ctx context.Context) (Configuration, error) {
+	var c Configuration
+	err := db.update(func(tx *sql.Tx) error {
+		err := createSqliteTables(tx)
+		if err != nil {
+			return err
+		}
+
+		var (
+			scanner = NewThemeScanner()
+			themes  = scanner.LoadThemes()
+		)
+
+		if len(themes) == 0 {
+			return ErrNoThemes
+		}
+
+		for k, v := range themes {
+			buf, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT OR REPLACE INTO themes (path, data) VALUES (?, ?)`, k, string(buf))
+			if err != nil {
+				return err
+			}
+		}
+
+		var v string
+		err = tx.QueryRow(`SELECT value FROM common WHERE key = ?`, "configuration").Scan(&v)
+		if err == nil {
+			return json.Unmarshal([]byte(v), &c)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		c, err = defaultConfiguration(themes)
+		if err != nil {
+			return err
+		}
+
+		err = putCommon(tx, "configuration", c)
+		if err != nil {
+			return err
+		}
+
+		err = putCommon(tx, "user", defaultUser())
+		if err != nil {
+			return err
+		}
+
+		routes := defaultRoutes()
+		for _, r := range routes {
+			_, err = tx.Exec(`INSERT OR REPLACE INTO routes (slug, title) VALUES (?, ?)`, r.Slug, r.Title)
+			if err != nil {
+				return err
+			}
+		}
+
+		return putMenu(tx, defaultMenu(routes))
+	})
+
+	if err != nil {
+		db.logger.Error("cannot create configuration", zap.Error(err))
+		return Configuration{}, ErrDatabase
+	}
+
+	return c, nil
+}
+
+func (db *sqliteDatabase) update(fn func(tx *sql.Tx) error) error {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *sqliteDatabase) getCommon(ctx context.Context, key string, v interface{}) error {
+	return db.getRecord(ctx, `SELECT value FROM common WHERE key = ?`, key, v)
+}
+
+func (db *sqliteDatabase) getRecord(ctx context.Context, query string, key string, v interface{}) error {
+	var data string
+	err := db.sql.QueryRowContext(ctx, query, key).Scan(&data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(data), v)
+}
+
+func (db *sqliteDatabase) queryRecords(ctx context.Context, query string, fn func(data []byte) error, args ...interface{}) error {
+	rows, err := db.sql.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func createSqliteTables(e sqlExecer) error {
+	for _, t := range sqliteTables {
+		if _, err := e.Exec(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putCommon(e sqlExecer, key string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(`INSERT OR REPLACE INTO common (key, value) VALUES (?, ?)`, key, string(buf))
+
+	return err
+}
+
+func putRecord(e sqlExecer, table string, slug string, title string, subtitle string, published time.Time, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.Exec(fmt.Sprintf(`INSERT OR REPLACE INTO %s (slug, title, subtitle, published, data) VALUES (?, ?, ?, ?, ?)`, table),
+		slug, title, subtitle, published.UTC().Format(time.RFC3339), string(buf))
+
+	return err
+}
+
+func putMenu(e sqlExecer, menu Menu) error {
+	if _, err := e.Exec(`DELETE FROM menu`); err != nil {
+		return err
+	}
+
+	for i, r := range menu {
+		_, err := e.Exec(`INSERT INTO menu (position, slug, title) VALUES (?, ?, ?)`, i, r.Slug, r.Title)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type sqliteSnapshotter struct {
+	sql *sql.DB
+}
+
+func NewSqliteSnapshotter(db *sql.DB) Snapshotter {
+	return &sqliteSnapshotter{
+		sql: db,
+	}
+}
+
+func (s *sqliteSnapshotter) Extension() string {
+	return SqliteBackupExtension
+}
+
+// Snapshot uses VACUUM INTO, which produces a consistent, compacted copy
+// of the live database without blocking readers.
+func (s *sqliteSnapshotter) Snapshot(p string) error {
+	_, err := s.sql.Exec(`VACUUM INTO ?`, p)
+	return err
+}
+
+func (s *sqliteSnapshotter) WriteTo(w io.Writer) (int64, error) {
+	f, err := ioutil.TempFile("", "showcase-snapshot-")
+	if err != nil {
+		return 0, err
+	}
+
+	p := f.Name()
+	f.Close()
+	os.Remove(p)
+	defer os.Remove(p)
+
+	if err = s.Snapshot(p); err != nil {
+		return 0, err
+	}
+
+	f, err = os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(w, f)
+}
+
+// CopyDatabase copies every record from one database into another. It is
+// used to move an existing site between storage backends. Besides the site
+// itself it copies history, trash, redirects, sessions, API keys, pending
+// password resets and the audit log. Failed sign ins are not copied, they
+// only throttle sign ins for a while.
+func CopyDatabase(ctx context.Context, from DB, to DB) error {
+	c, err := from.GetConfiguration(ctx)
+	if err != nil {
+		return err
+	}
+
+	as, err := from.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	u, err := from.GetUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	r, err := from.GetRoutes(ctx)
+	if err != nil {
+		return err
+	}
+
+	m, err := from.GetMenu(ctx)
+	if err != nil {
+		return err
+	}
+
+	ps, err := from.GetProjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	cs, err := from.GetContents(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = to.PutConfiguration(&c); err != nil {
+		return err
+	}
+
+	if len(as) > 0 {
+		if err = to.ReplaceAccounts(as); err != nil {
+			return err
+		}
+	}
+
+	if err = to.PutUser(&u); err != nil {
+		return err
+	}
+
+	for _, v := range r {
+		route := v
+		if err = to.PutRoute(&route); err != nil {
+			return err
+		}
+	}
+
+	for i := range ps {
+		if err = to.PutProject(&ps[i]); err != nil {
+			return err
+		}
+	}
+
+	for i := range cs {
+		if err = to.PutContent(&cs[i]); err != nil {
+			return err
+		}
+	}
+
+	if err = to.PutMenu(&m); err != nil {
+		return err
+	}
+
+	trash, err := from.GetTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Items come newest first, they are added oldest first so the new ids
+	// keep their order. The same goes for revisions and audit entries.
+	for i := len(trash) - 1; i >= 0; i-- {
+		if err = to.PutTrashItem(&trash[i]); err != nil {
+			return err
+		}
+	}
+
+	if err = copyHistory(ctx, from, to, ps, cs, trash); err != nil {
+		return err
+	}
+
+	rs, err := from.GetRedirects(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range rs {
+		if err = to.PutRedirect(&rs[i]); err != nil {
+			return err
+		}
+	}
+
+	if err = copyAccess(ctx, from, to, as); err != nil {
+		return err
+	}
+
+	return copyAuditLog(ctx, from, to)
+}
+
+// copyHistory copies the revisions of every live and trashed item.
+func copyHistory(ctx context.Context, from DB, to DB, ps []Project, cs []Content, trash []TrashItem) error {
+	type item struct {
+		kind RevisionKind
+		slug string
+	}
+
+	var (
+		items []item
+		seen  = make(map[item]bool)
+	)
+
+	add := func(kind RevisionKind, slug string) {
+		if k := (item{kind, slug}); !seen[k] {
+			seen[k] = true
+			items = append(items, k)
+		}
+	}
+
+	for _, v := range ps {
+		add(RevisionProject, v.Slug)
+	}
+	for _, v := range cs {
+		add(RevisionContent, v.Slug)
+	}
+	for _, v := range trash {
+		add(v.Kind, v.Slug)
+	}
+
+	for _, v := range items {
+		rs, err := from.GetRevisions(ctx, v.kind, v.slug)
+		if err != nil {
+			return err
+		}
+
+		for i := len(rs) - 1; i >= 0; i-- {
+			if err := to.PutRevision(&rs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyAccess copies the sessions, API keys and pending password resets of
+// the accounts, so nobody is signed out by a move.
+func copyAccess(ctx context.Context, from DB, to DB, as []Account) error {
+	for _, a := range as {
+		ss, err := from.GetSessions(ctx, a.ID)
+		if err != nil {
+			return err
+		}
+
+		for i := range ss {
+			if err := to.PutSession(&ss[i]); err != nil {
+				return err
+			}
+		}
+
+		r, err := from.GetPasswordReset(ctx, a.ID)
+		if err == ErrInvalidResetToken {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := to.PutPasswordReset(&r); err != nil {
+			return err
+		}
+	}
+
+	ks, err := from.GetAPIKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range ks {
+		if err := to.PutAPIKey(&ks[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyAuditLog(ctx context.Context, from DB, to DB) error {
+	var (
+		es []AuditEntry
+		f  = AuditFilter{Limit: AuditMaxPageSize}
+	)
+
+	for {
+		page, err := from.GetAuditEntries(ctx, f)
+		if err != nil {
+			return err
+		}
+
+		es = append(es, page...)
+
+		if len(page) < f.limit() {
+			break
+		}
+
+		f.Before = page[len(page)-1].ID
+	}
+
+	for i := len(es) - 1; i >= 0; i-- {
+		if err := to.PutAuditEntry(&es[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
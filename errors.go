@@ -13,4 +13,41 @@ var (
 	ErrMediaNotSupported     = errors.New("This media is not supported yet")
 	ErrSetupEmpty            = errors.New("Please fill in required fields")
 	ErrConfigurationTimedOut = errors.New("Configuration timed out")
+	ErrSchemaTooNew          = errors.New("Database was written by a newer version of showcase")
+	ErrInvalidArchive        = errors.New("Archive is invalid or incomplete")
+	ErrUnknownDriver         = errors.New("Unknown database driver")
+	ErrUnknownCache          = errors.New("Unknown cache, either memory, lru or redis")
+	ErrRevisionNotFound      = errors.New("Revision not found")
+	ErrProjectNotFound       = errors.New("Project not found")
+	ErrContentNotFound       = errors.New("Content not found")
+	ErrTrashItemNotFound     = errors.New("Item not found in trash")
+	ErrRedirectNotFound      = errors.New("Redirect not found")
+	ErrInvalidOrder          = errors.New("Order must list every project exactly once")
+	ErrInvalidProjectOrder   = errors.New("Project order must be manual, newest or oldest")
+	ErrAccountNotFound       = errors.New("Account not found")
+	ErrAccountExists         = errors.New("Account with this email already exists")
+	ErrLastOwner             = errors.New("At least one owner account must remain")
+	ErrInvalidRole           = errors.New("Role must be owner, editor or viewer")
+	ErrSessionNotFound       = errors.New("Session not found")
+	ErrLoginFailed           = errors.New("Incorrect email or password, or too many attempts")
+	ErrTOTPEnabled           = errors.New("Two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled       = errors.New("Two-factor authentication is not enrolled")
+	ErrInvalidTOTPCode       = errors.New("Invalid two-factor code")
+	ErrAPIKeyNotFound        = errors.New("API key not found")
+	ErrInvalidScope          = errors.New("Unknown API key scope")
+	ErrInvalidResetToken     = errors.New("Invalid or expired password reset token")
+	ErrUnknownMailer         = errors.New("Mail server must be given as smtp://[user:password@]host:port")
+	ErrMailerDisabled        = errors.New("No mail server configured")
+	ErrInvalidMailHeader     = errors.New("Mail header must not contain line breaks")
+	ErrSessionExpired        = errors.New("Session has expired")
+	ErrInvalidRefreshToken   = errors.New("Invalid refresh token")
+	ErrRefreshTokenReused    = errors.New("Refresh token was already used, the session has been revoked")
+	ErrInvalidStatus         = errors.New("Status must be draft, published or scheduled with a publication time")
+	ErrInvalidAuditFilter    = errors.New("Audit filter times must be RFC 3339, before and limit must be numbers")
+	ErrInvalidCorsOrigin     = errors.New("CORS origin pattern is malformed")
+	ErrCorsCredentials       = errors.New("CORS credentials cannot be allowed for every origin")
+	ErrInvalidCSPMode        = errors.New("CSP mode must be enforce, report-only or off")
+	ErrCredentialsEmpty      = errors.New("Email and password must not be empty")
+	ErrWrongPassword         = errors.New("Current password is incorrect")
+	ErrArchiveTooLarge       = errors.New("Archive or one of its files is too large")
 )
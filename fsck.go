@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// Checker looks for records that disagree with each other or with the
+// media directory, and repairs the cases that are safe to fix.
+type Checker struct {
+	db     DB
+	logger *zap.Logger
+}
+
+func NewChecker(db DB, logger *zap.Logger) *Checker {
+	return &Checker{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Check scans the database and the media directory and reports every
+// problem found. With repair set, dangling menu entries and orphaned routes
+// are removed and missing routes of pages are recreated, media problems
+// are only reported.
+func (c *Checker) Check(ctx context.Context, repair bool) ([]Problem, error) {
+	routes, err := c.db.GetRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	menu, err := c.db.GetMenu(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := c.db.GetContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := c.db.GetProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := c.db.GetUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := referencedMedia(ctx, c.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		problems []Problem
+		pages    = make(map[string]Content, len(cs))
+		builtin  = make(map[string]bool)
+	)
+
+	for _, v := range cs {
+		pages[v.Slug] = v
+	}
+	for _, v := range defaultRoutes() {
+		builtin[v.Slug] = true
+	}
+
+	for _, k := range menuKeys(menu) {
+		if _, ok := routes[menu[k].Slug]; !ok {
+			problems = append(problems, Problem{
+				Type:    ProblemDanglingMenuEntry,
+				Subject: menu[k].Slug,
+				Detail:  fmt.Sprintf("menu entry %d has no route", k),
+			})
+		}
+	}
+
+	slugs := make([]string, 0, len(routes))
+	for k := range routes {
+		slugs = append(slugs, k)
+	}
+	sort.Strings(slugs)
+
+	for _, v := range slugs {
+		if _, ok := pages[v]; !ok && !builtin[v] {
+			problems = append(problems, Problem{
+				Type:    ProblemOrphanedRoute,
+				Subject: v,
+				Detail:  "route has no page",
+			})
+		}
+	}
+
+	for _, v := range cs {
+		if _, ok := routes[v.Slug]; !ok {
+			problems = append(problems, Problem{
+				Type:    ProblemMissingRoute,
+				Subject: v.Slug,
+				Detail:  "page has no route",
+			})
+		}
+	}
+
+	owners := make(map[string][]*Media)
+	owners["user"] = userMedia(&u)
+	for i := range ps {
+		owners["project "+ps[i].Slug] = projectMedia(&ps[i])
+	}
+	for i := range cs {
+		owners["content "+cs[i].Slug] = contentMedia(&cs[i])
+	}
+
+	keys := make([]string, 0, len(owners))
+	for k := range owners {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, m := range owners[k] {
+			if m.Path == "" {
+				continue
+			}
+
+			if _, err := os.Stat(m.Path); os.IsNotExist(err) {
+				problems = append(problems, Problem{
+					Type:    ProblemMissingMedia,
+					Subject: m.Path,
+					Detail:  fmt.Sprintf("file of %s is gone", k),
+				})
+			}
+		}
+	}
+
+	for _, t := range []MediaType{MediaImage, MediaVideo} {
+		files, err := ioutil.ReadDir(filepath.Join(MediaPath, paths[t]))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, f := range files {
+			p := filepath.Join(MediaPath, paths[t], f.Name())
+			if f.IsDir() || used[p] {
+				continue
+			}
+
+			problems = append(problems, Problem{
+				Type:    ProblemUnreferencedMedia,
+				Subject: p,
+				Detail:  "file is not used by any record",
+			})
+		}
+	}
+
+	if repair {
+		if err := c.repair(problems, pages); err != nil {
+			return problems, err
+		}
+	}
+
+	return problems, nil
+}
+
+// repair fixes the problems that only concern routes and the menu, all
+// in one unit of work.
+func (c *Checker) repair(problems []Problem, pages map[string]Content) error {
+	var fixable []*Problem
+	for i := range problems {
+		switch problems[i].Type {
+		case ProblemDanglingMenuEntry, ProblemOrphanedRoute, ProblemMissingRoute:
+			fixable = append(fixable, &problems[i])
+		}
+	}
+
+	if len(fixable) == 0 {
+		return nil
+	}
+
+	err := c.db.Atomic(func(tx Tx) error {
+		m, err := tx.GetMenu()
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+		for _, p := range fixable {
+			switch p.Type {
+			case ProblemDanglingMenuEntry:
+				_, ok := removeMenuEntry(m, p.Subject)
+				changed = changed || ok
+			case ProblemOrphanedRoute:
+				if err := tx.DeleteRoute(p.Subject); err != nil {
+					return err
+				}
+
+				_, ok := removeMenuEntry(m, p.Subject)
+				changed = changed || ok
+			case ProblemMissingRoute:
+				err := tx.PutRoute(&Route{
+					Title: pages[p.Subject].Title,
+					Slug:  p.Subject,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if changed {
+			return tx.PutMenu(&m)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range fixable {
+		p.Repaired = true
+
+		c.logger.Info("repaired",
+			zap.String("problem", string(p.Type)),
+			zap.String("subject", p.Subject))
+	}
+
+	return nil
+}
+
+// Bolt
+func (t *boltTx) DeleteRoute(slug string) error {
+	err := t.tx.Bucket([]byte(BUCKET_ROUTES)).Delete([]byte(slug))
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("routes")
+
+	return nil
+}
+
+// SQLite
+func (t *sqliteTx) DeleteRoute(slug string) error {
+	_, err := t.tx.Exec(`DELETE FROM routes WHERE slug = ?`, slug)
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("routes")
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	QuarantinePath string = "quarantine"
+
+	MediaGracePeriod       time.Duration = time.Hour * 24
+	MediaCollectorInterval time.Duration = time.Hour * 24
+)
+
+// MediaCollector removes media files that no record references anymore.
+// Files younger than the grace period are left alone, so an upload whose
+// record has not been written yet is never collected. With a quarantine
+// directory set, files are moved there instead of being deleted.
+type MediaCollector struct {
+	db         DB
+	mm         *MediaManager
+	logger     *zap.Logger
+	grace      time.Duration
+	quarantine string
+	interval   time.Duration
+	stop       chan bool
+}
+
+func NewMediaCollector(db DB, mm *MediaManager, logger *zap.Logger, grace time.Duration, quarantine string, interval time.Duration) *MediaCollector {
+	return &MediaCollector{
+		db:         db,
+		mm:         mm,
+		logger:     logger,
+		grace:      grace,
+		quarantine: quarantine,
+		interval:   interval,
+		stop:       make(chan bool),
+	}
+}
+
+func (c *MediaCollector) Run() {
+	ticker := time.NewTicker(c.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := c.Collect(context.TODO()); err != nil {
+					c.logger.Error("cannot collect media", zap.Error(err))
+				}
+			case <-c.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Collect removes every unreferenced media file older than the grace
+// period and reports what it freed.
+func (c *MediaCollector) Collect(ctx context.Context) (MediaCollection, error) {
+	var r = MediaCollection{
+		Files:      make([]string, 0),
+		Quarantine: c.quarantine,
+	}
+
+	used, err := referencedMedia(ctx, c.db)
+	if err != nil {
+		return r, err
+	}
+
+	for _, t := range []MediaType{MediaImage, MediaVideo} {
+		files, err := ioutil.ReadDir(filepath.Join(MediaPath, paths[t]))
+		if err != nil && !os.IsNotExist(err) {
+			return r, err
+		}
+
+		for _, f := range files {
+			p := filepath.Join(MediaPath, paths[t], f.Name())
+			if f.IsDir() || used[p] || time.Since(f.ModTime()) < c.grace {
+				continue
+			}
+
+			if c.quarantine != "" {
+				err = c.mm.Quarantine(p, c.quarantine)
+			} else {
+				err = c.mm.Delete(&Media{Path: p})
+			}
+
+			if err != nil {
+				c.logger.Warn("cannot collect media", zap.String("path", p), zap.Error(err))
+				continue
+			}
+
+			r.Files = append(r.Files, p)
+			r.Bytes += f.Size()
+		}
+	}
+
+	c.logger.Info("media collected",
+		zap.Int("files", len(r.Files)),
+		zap.Int64("bytes", r.Bytes),
+		zap.String("quarantine", c.quarantine))
+
+	return r, nil
+}
+
+func (c *MediaCollector) Finalize() {
+	c.stop <- true
+}
+
+// liveMedia returns the paths of every media file used by the user, a
+// project or a page.
+func liveMedia(ctx context.Context, db DB) (map[string]bool, error) {
+	u, err := db.GetUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := db.GetProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := db.GetContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := userMedia(&u)
+	for i := range ps {
+		refs = append(refs, projectMedia(&ps[i])...)
+	}
+	for i := range cs {
+		refs = append(refs, contentMedia(&cs[i])...)
+	}
+
+	used := make(map[string]bool, len(refs))
+	for _, m := range refs {
+		if m.Path != "" {
+			used[filepath.Clean(m.Path)] = true
+		}
+	}
+
+	return used, nil
+}
+
+// referencedMedia extends liveMedia with the files of trashed items and of
+// every stored revision, so restoring either never brings back a record
+// whose media is gone.
+func referencedMedia(ctx context.Context, db DB) (map[string]bool, error) {
+	used, err := liveMedia(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	ps, err := db.GetProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := db.GetContents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trash, err := db.GetTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type item struct {
+		kind RevisionKind
+		slug string
+	}
+
+	var items []item
+	for _, v := range ps {
+		items = append(items, item{RevisionProject, v.Slug})
+	}
+	for _, v := range cs {
+		items = append(items, item{RevisionContent, v.Slug})
+	}
+	for _, v := range trash {
+		for _, p := range v.Media {
+			used[filepath.Clean(p)] = true
+		}
+
+		items = append(items, item{v.Kind, v.Slug})
+	}
+
+	for _, v := range items {
+		rs, err := db.GetRevisions(ctx, v.kind, v.slug)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rev := range rs {
+			ms, err := revisionMedia(rev)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, m := range ms {
+				if m.Path != "" {
+					used[filepath.Clean(m.Path)] = true
+				}
+			}
+		}
+	}
+
+	return used, nil
+}
+
+func revisionMedia(rev Revision) ([]*Media, error) {
+	switch rev.Kind {
+	case RevisionProject:
+		var p Project
+		if err := json.Unmarshal(rev.Data, &p); err != nil {
+			return nil, err
+		}
+
+		return projectMedia(&p), nil
+	case RevisionContent:
+		var c Content
+		if err := json.Unmarshal(rev.Data, &c); err != nil {
+			return nil, err
+		}
+
+		return contentMedia(&c), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_HISTORY string = "history"
+
+	DefaultHistoryLimit int = 20
+)
+
+func NewRevision(kind RevisionKind, slug string, author string, v interface{}) (Revision, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	return Revision{
+		Kind:    kind,
+		Slug:    slug,
+		Author:  author,
+		Created: time.Now(),
+		Data:    buf,
+	}, nil
+}
+
+// DiffRevisions compares two revisions field by field. Nested objects are
+// compared recursively and reported with dotted paths, lists of the same
+// length element by element and everything else as a whole.
+func DiffRevisions(from, to Revision) ([]FieldChange, error) {
+	var a, b interface{}
+
+	if err := json.Unmarshal(from.Data, &a); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(to.Data, &b); err != nil {
+		return nil, err
+	}
+
+	changes := make([]FieldChange, 0)
+	diffValues("", a, b, &changes)
+
+	return changes, nil
+}
+
+func diffValues(field string, a, b interface{}, changes *[]FieldChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(av)+len(bv))
+		for k := range av {
+			keys = append(keys, k)
+		}
+		for k := range bv {
+			if _, ok := av[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			f := k
+			if field != "" {
+				f = field + "." + k
+			}
+
+			diffValues(f, av[k], bv[k], changes)
+		}
+
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			break
+		}
+
+		for i := range av {
+			diffValues(fmt.Sprintf("%s[%d]", field, i), av[i], bv[i], changes)
+		}
+
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, FieldChange{
+			Field: field,
+			Old:   a,
+			New:   b,
+		})
+	}
+}
+
+// itemKey identifies a project or page across buckets that hold both.
+func itemKey(kind RevisionKind, slug string) []byte {
+	return []byte(string(kind) + "/" + slug)
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Bolt
+func (db *cachedDatabase) GetRevisions(ctx context.Context, kind RevisionKind, slug string) ([]Revision, error) {
+	rs := make([]Revision, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_HISTORY))
+		if b == nil {
+			return nil
+		}
+
+		h := b.Bucket(itemKey(kind, slug))
+		if h == nil {
+			return nil
+		}
+
+		c := h.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var r Revision
+			err := json.Unmarshal(v, &r)
+			if err != nil {
+				return err
+			}
+			rs = append(rs, r)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get revisions", zap.Error(err))
+		return rs, ErrDatabase
+	}
+
+	return rs, nil
+}
+
+func (db *cachedDatabase) GetRevision(ctx context.Context, kind RevisionKind, slug string, id uint64) (Revision, error) {
+	var r Revision
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_HISTORY))
+		if b == nil {
+			return ErrRevisionNotFound
+		}
+
+		h := b.Bucket(itemKey(kind, slug))
+		if h == nil {
+			return ErrRevisionNotFound
+		}
+
+		v := h.Get(itob(id))
+		if v == nil {
+			return ErrRevisionNotFound
+		}
+
+		return json.Unmarshal(v, &r)
+	})
+
+	if err == ErrRevisionNotFound {
+		return Revision{}, err
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get revision", zap.Error(err))
+		return Revision{}, ErrDatabase
+	}
+
+	return r, nil
+}
+
+func (db *cachedDatabase) PutRevision(revision *Revision) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutRevision(revision)
+	})
+}
+
+// PreserveRevision records the stored state of an item without history, one
+// saved before history was kept, so the first tracked save does not lose it.
+func (t *boltTx) PreserveRevision(kind RevisionKind, slug string) error {
+	if b := t.tx.Bucket([]byte(BUCKET_HISTORY)); b != nil && b.Bucket(itemKey(kind, slug)) != nil {
+		return nil
+	}
+
+	bucket := BUCKET_PROJECTS
+	if kind == RevisionContent {
+		bucket = BUCKET_CONTENT
+	}
+
+	v := t.tx.Bucket([]byte(bucket)).Get([]byte(slug))
+	if v == nil {
+		return nil
+	}
+
+	return t.PutRevision(&Revision{
+		Kind:    kind,
+		Slug:    slug,
+		Created: time.Now(),
+		Data:    append(json.RawMessage(nil), v...),
+	})
+}
+
+func putRevision(tx *bolt.Tx, revision *Revision, limit int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_HISTORY))
+	if err != nil {
+		return err
+	}
+
+	h, err := b.CreateBucketIfNotExists(itemKey(revision.Kind, revision.Slug))
+	if err != nil {
+		return err
+	}
+
+	id, err := h.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	revision.ID = id
+
+	err = save(h, itob(id), revision)
+	if err != nil {
+		return err
+	}
+
+	if limit <= 0 {
+		return nil
+	}
+
+	var keys [][]byte
+	c := h.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for i := 0; i < len(keys)-limit; i++ {
+		if err := h.Delete(keys[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SQLite
+func (db *sqliteDatabase) GetRevisions(ctx context.Context, kind RevisionKind, slug string) ([]Revision, error) {
+	rs := make([]Revision, 0)
+
+	rows, err := db.sql.QueryContext(ctx, `SELECT id, kind, slug, author, created, data FROM revisions WHERE kind = ? AND slug = ? ORDER BY id DESC`, string(kind), slug)
+	if err == nil {
+		defer rows.Close()
+
+		for rows.Next() {
+			var r Revision
+			if r, err = scanRevision(rows); err != nil {
+				break
+			}
+			rs = append(rs, r)
+		}
+
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get revisions", zap.Error(err))
+		return rs, ErrDatabase
+	}
+
+	return rs, nil
+}
+
+func (db *sqliteDatabase) GetRevision(ctx context.Context, kind RevisionKind, slug string, id uint64) (Revision, error) {
+	row := db.sql.QueryRowContext(ctx, `SELECT id, kind, slug, author, created, data FROM revisions WHERE kind = ? AND slug = ? AND id = ?`, string(kind), slug, id)
+
+	r, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return Revision{}, ErrRevisionNotFound
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get revision", zap.Error(err))
+		return Revision{}, ErrDatabase
+	}
+
+	return r, nil
+}
+
+func (db *sqliteDatabase) PutRevision(revision *Revision) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.PutRevision(revision)
+	})
+}
+
+func (t *sqliteTx) PreserveRevision(kind RevisionKind, slug string) error {
+	var n int
+	err := t.tx.QueryRow(`SELECT COUNT(*) FROM revisions WHERE kind = ? AND slug = ?`, string(kind), slug).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	table := "projects"
+	if kind == RevisionContent {
+		table = "contents"
+	}
+
+	var data string
+	err = t.tx.QueryRow(fmt.Sprintf(`SELECT data FROM %s WHERE slug = ?`, table), slug).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return t.PutRevision(&Revision{
+		Kind:    kind,
+		Slug:    slug,
+		Created: time.Now(),
+		Data:    json.RawMessage(data),
+	})
+}
+
+func putSqlRevision(tx *sql.Tx, revision *Revision, limit int) error {
+	res, err := tx.Exec(`INSERT INTO revisions (kind, slug, author, created, data) VALUES (?, ?, ?, ?, ?)`,
+		string(revision.Kind), revision.Slug, revision.Author, revision.Created.UTC().Format(time.RFC3339Nano), string(revision.Data))
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	revision.ID = uint64(id)
+
+	if limit <= 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(`DELETE FROM revisions WHERE kind = ? AND slug = ? AND id NOT IN (
+		SELECT id FROM revisions WHERE kind = ? AND slug = ? ORDER BY id DESC LIMIT ?
+	)`, string(revision.Kind), revision.Slug, string(revision.Kind), revision.Slug, limit)
+
+	return err
+}
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRevision(s sqlScanner) (Revision, error) {
+	var (
+		r       Revision
+		kind    string
+		created string
+		data    string
+	)
+
+	err := s.Scan(&r.ID, &kind, &r.Slug, &r.Author, &created, &data)
+	if err != nil {
+		return r, err
+	}
+
+	r.Kind = RevisionKind(kind)
+	r.Data = json.RawMessage(data)
+	r.Created, err = time.Parse(time.RFC3339Nano, created)
+
+	return r, err
+}
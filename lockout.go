@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_LOGINS string = "logins"
+
+	LoginMaxAttempts   int           = 5
+	LoginIPMaxAttempts int           = 20
+	LoginBackoff       time.Duration = time.Second
+	LoginLockout       time.Duration = 15 * time.Minute
+	LoginPruneInterval time.Duration = time.Hour
+
+	// UnlockAll lifts every lockout when passed to -unlock.
+	UnlockAll string = "all"
+)
+
+// LoginPolicy throttles failed sign ins. Every failure doubles the wait
+// before the next attempt, starting at Backoff and never longer than
+// Lockout. A key that keeps failing is locked out for Lockout, failures
+// older than that are forgotten.
+type LoginPolicy struct {
+	// MaxAttempts is the number of failures per email before a lockout,
+	// 0 never locks an email out.
+	MaxAttempts int
+
+	// IPMaxAttempts is the number of failures per address before a
+	// lockout, 0 never locks an address out.
+	IPMaxAttempts int
+
+	Backoff time.Duration
+	Lockout time.Duration
+}
+
+func accountLoginKey(email string) string {
+	return "account:" + normalizeEmail(email)
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// wait returns how long a key has to wait before its next attempt.
+func (p LoginPolicy) wait(a LoginAttempts, now time.Time) time.Duration {
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	if a.Failures == 0 || p.Backoff <= 0 || p.expired(a, now) {
+		return 0
+	}
+
+	var d = p.Backoff
+	for i := 1; i < a.Failures && (p.Lockout <= 0 || d < p.Lockout); i++ {
+		d *= 2
+	}
+
+	if p.Lockout > 0 && d > p.Lockout {
+		d = p.Lockout
+	}
+
+	if next := a.Last.Add(d); now.Before(next) {
+		return next.Sub(now)
+	}
+
+	return 0
+}
+
+func (p LoginPolicy) expired(a LoginAttempts, now time.Time) bool {
+	if !a.LockedUntil.IsZero() {
+		return !now.Before(a.LockedUntil)
+	}
+
+	return p.Lockout > 0 && now.Sub(a.Last) > p.Lockout
+}
+
+// fail records a failure and reports whether it locked the key out.
+func (p LoginPolicy) fail(a *LoginAttempts, max int, now time.Time) bool {
+	if p.expired(*a, now) {
+		a.Failures = 0
+		a.LockedUntil = time.Time{}
+	}
+
+	a.Failures++
+	a.Last = now
+
+	if max > 0 && a.Failures >= max {
+		a.LockedUntil = now.Add(p.Lockout)
+		return true
+	}
+
+	return false
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// loginDummyHash is compared against when an email is unknown, so that
+// failure takes as long as a wrong password.
+func loginDummyHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte(GenerateSecret(12)), bcrypt.DefaultCost)
+	})
+
+	return dummyHash
+}
+
+type loginKey struct {
+	key string
+	max int
+}
+
+// loginKeys lists the keys a sign in is throttled by, the address first.
+func (s *Server) loginKeys(r *http.Request, email string) []loginKey {
+	return []loginKey{
+		{key: ipLoginKey(clientIP(r)), max: s.ao.Login.IPMaxAttempts},
+		{key: accountLoginKey(email), max: s.ao.Login.MaxAttempts},
+	}
+}
+
+// loginWait returns how long a sign in has to wait, the longest wait of
+// any of its keys.
+func (s *Server) loginWait(ctx context.Context, keys []loginKey, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	for _, k := range keys {
+		a, err := s.db.GetLoginAttempts(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+
+		if d := s.ao.Login.wait(a, now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, nil
+}
+
+// loginThrottled answers a sign in that has to wait and reports whether it
+// did.
+func (s *Server) loginThrottled(w http.ResponseWriter, ctx context.Context, keys []loginKey, now time.Time) bool {
+	wait, err := s.loginWait(ctx, keys, now)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return true
+	}
+
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		http.Error(w, ErrLoginFailed.Error(), http.StatusUnauthorized)
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) loginFailed(r *http.Request, keys []loginKey, now time.Time) {
+	for _, k := range keys {
+		var locked bool
+
+		a, err := s.db.UpdateLoginAttempts(k.key, func(a *LoginAttempts) error {
+			locked = s.ao.Login.fail(a, k.max, now)
+			return nil
+		})
+
+		if err != nil {
+			s.l.Error("cannot record login failure", zap.String("key", k.key), zap.Error(err))
+			continue
+		}
+
+		if locked {
+			s.l.Warn("login locked out",
+				zap.String("key", k.key),
+				zap.Int("failures", a.Failures),
+				zap.Time("until", a.LockedUntil))
+
+			e := newAuditEntry(r, AuditLoginLockout)
+			e.Target = k.key
+			e.Status = http.StatusUnauthorized
+			e.Error = fmt.Sprintf("%d failures, locked until %s", a.Failures, a.LockedUntil.Format(time.RFC3339))
+
+			s.au.Record(e)
+		}
+	}
+}
+
+func (s *Server) loginSucceeded(keys []loginKey) {
+	for _, k := range keys {
+		if err := s.db.DeleteLoginAttempts(k.key); err != nil {
+			s.l.Error("cannot reset login failures", zap.String("key", k.key), zap.Error(err))
+		}
+	}
+}
+
+// LoginSweeper forgets failed sign ins once their lockout ended or they are
+// older than the lockout, so addresses and emails that stopped trying do
+// not pile up.
+type LoginSweeper struct {
+	db     DB
+	logger *zap.Logger
+	policy LoginPolicy
+	stop   chan bool
+}
+
+func NewLoginSweeper(db DB, logger *zap.Logger, policy LoginPolicy) *LoginSweeper {
+	return &LoginSweeper{
+		db:     db,
+		logger: logger,
+		policy: policy,
+		stop:   make(chan bool),
+	}
+}
+
+func (s *LoginSweeper) Run() {
+	s.Expire()
+
+	ticker := time.NewTicker(LoginPruneInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.Expire()
+			case <-s.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (s *LoginSweeper) Expire() {
+	now := time.Now()
+
+	n, err := s.db.PruneLoginAttempts(func(a LoginAttempts) bool {
+		return s.policy.expired(a, now)
+	})
+
+	if err != nil {
+		s.logger.Error("cannot prune login attempts", zap.Error(err))
+		return
+	}
+
+	if n > 0 {
+		s.logger.Info("login attempts pruned", zap.Int("keys", n))
+	}
+}
+
+func (s *LoginSweeper) Finalize() {
+	s.stop <- true
+}
+
+// unlockLogin lifts the lockout of an email or address, or of everyone.
+func unlockLogin(db DB, target string) error {
+	if target == UnlockAll {
+		return db.ClearLoginAttempts()
+	}
+
+	if net.ParseIP(target) != nil {
+		return db.DeleteLoginAttempts(ipLoginKey(target))
+	}
+
+	return db.DeleteLoginAttempts(accountLoginKey(target))
+}
+
+// Bolt
+func (db *cachedDatabase) GetLoginAttempts(ctx context.Context, key string) (LoginAttempts, error) {
+	var a = LoginAttempts{Key: key}
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_LOGINS))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(v, &a)
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get login attempts", zap.Error(err))
+		return a, ErrDatabase
+	}
+
+	return a, nil
+}
+
+// UpdateLoginAttempts applies a change to the attempts of a key, which
+// start out empty.
+func (db *cachedDatabase) UpdateLoginAttempts(key string, fn func(a *LoginAttempts) error) (LoginAttempts, error) {
+	var a = LoginAttempts{Key: key}
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_LOGINS))
+		if err != nil {
+			return err
+		}
+
+		if v := b.Get([]byte(key)); v != nil {
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(&a); err != nil {
+			return err
+		}
+
+		return save(b, []byte(key), a)
+	})
+
+	return a, err
+}
+
+func (db *cachedDatabase) DeleteLoginAttempts(key string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_LOGINS))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// PruneLoginAttempts deletes the attempts of every key that expired says
+// are over.
+func (db *cachedDatabase) PruneLoginAttempts(expired func(a LoginAttempts) bool) (int, error) {
+	var n int
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_LOGINS))
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var a LoginAttempts
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+
+			if expired(a) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		n = len(keys)
+		return nil
+	})
+
+	return n, err
+}
+
+func (db *cachedDatabase) ClearLoginAttempts() error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(BUCKET_LOGINS)) == nil {
+			return nil
+		}
+
+		return tx.DeleteBucket([]byte(BUCKET_LOGINS))
+	})
+}
+
+// SQLite
+func (db *sqliteDatabase) GetLoginAttempts(ctx context.Context, key string) (LoginAttempts, error) {
+	var a = LoginAttempts{Key: key}
+	err := db.getRecord(ctx, `SELECT data FROM logins WHERE key = ?`, key, &a)
+	if err == sql.ErrNoRows {
+		return a, nil
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get login attempts", zap.Error(err))
+		return a, ErrDatabase
+	}
+
+	return a, nil
+}
+
+func (db *sqliteDatabase) UpdateLoginAttempts(key string, fn func(a *LoginAttempts) error) (LoginAttempts, error) {
+	var a = LoginAttempts{Key: key}
+	err := db.update(func(tx *sql.Tx) error {
+		var data string
+
+		err := tx.QueryRow(`SELECT data FROM logins WHERE key = ?`, key).Scan(&data)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if err == nil {
+			if err := json.Unmarshal([]byte(data), &a); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(&a); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT OR REPLACE INTO logins (key, data) VALUES (?, ?)`, key, string(b))
+		return err
+	})
+
+	return a, err
+}
+
+func (db *sqliteDatabase) DeleteLoginAttempts(key string) error {
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM logins WHERE key = ?`, key)
+		return err
+	})
+}
+
+func (db *sqliteDatabase) PruneLoginAttempts(expired func(a LoginAttempts) bool) (int, error) {
+	var n int
+	err := db.update(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT key, data FROM logins`)
+		if err != nil {
+			return err
+		}
+
+		var keys []string
+		for rows.Next() {
+			var key, data string
+			if err := rows.Scan(&key, &data); err != nil {
+				rows.Close()
+				return err
+			}
+
+			var a LoginAttempts
+			if err := json.Unmarshal([]byte(data), &a); err != nil {
+				rows.Close()
+				return err
+			}
+
+			if expired(a) {
+				keys = append(keys, key)
+			}
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if _, err := tx.Exec(`DELETE FROM logins WHERE key = ?`, key); err != nil {
+				return err
+			}
+		}
+
+		n = len(keys)
+		return nil
+	})
+
+	return n, err
+}
+
+func (db *sqliteDatabase) ClearLoginAttempts() error {
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM logins`)
+		return err
+	})
+}
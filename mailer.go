@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// NewMailer returns a mailer for an address given as
+// smtp://[user:password@]host:port, or one that only logs when the address
+// is empty.
+func NewMailer(addr string, from string, logger *zap.Logger) (Mailer, error) {
+	if addr == "" {
+		return logMailer{logger: logger}, nil
+	}
+
+	return NewSMTPMailer(addr, from)
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr string, from string) (Mailer, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "smtp" || u.Host == "" {
+		return nil, ErrUnknownMailer
+	}
+
+	m := &smtpMailer{
+		addr: u.Host,
+		from: from,
+	}
+
+	// PLAIN is refused over connections that are neither TLS nor local.
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+
+	return m, nil
+}
+
+func (m *smtpMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return ErrInvalidMailHeader
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n%s\r\n", body)
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, b.Bytes())
+}
+
+// logMailer stands in when no mail server is configured. It never logs the
+// body, which may hold secrets.
+type logMailer struct {
+	logger *zap.Logger
+}
+
+func (m logMailer) Send(to string, subject string, body string) error {
+	m.logger.Warn("no mail server configured, email not sent", zap.String("to", to), zap.String("subject", subject))
+
+	return ErrMailerDisabled
+}
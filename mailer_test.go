@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data []byte
+}
+
+// smtpStub is an in-process server accepting mail the way net/smtp sends
+// it, without authentication or TLS.
+type smtpStub struct {
+	l        net.Listener
+	messages chan smtpMessage
+	conns    int32
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpStub{
+		l:        l,
+		messages: make(chan smtpMessage, 1),
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt32(&s.conns, 1)
+			go s.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { l.Close() })
+
+	return s
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		tp  = textproto.NewConn(conn)
+		msg smtpMessage
+	)
+
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			msg.from = smtpPath(line)
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, smtpPath(line))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+
+			if msg.data, err = tp.ReadDotBytes(); err != nil {
+				return
+			}
+
+			s.messages <- msg
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// smtpPath returns the address of a MAIL FROM or RCPT TO command.
+func smtpPath(line string) string {
+	i, j := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if i < 0 || j < i {
+		return ""
+	}
+
+	return line[i+1 : j]
+}
+
+func (s *smtpStub) receive(t *testing.T) smtpMessage {
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	return smtpMessage{}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	s := newSMTPStub(t)
+
+	m, err := NewSMTPMailer("smtp://"+s.l.Addr().String(), "showcase@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		subject = "Réinitialiser le mot de passe"
+		body    = "Open this link:\nhttps://example.com/reset?token=abc\n.\nThe line above is a single dot."
+	)
+
+	if err := m.Send("jane@example.com", subject, body); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := s.receive(t)
+
+	if msg.from != "showcase@example.com" {
+		t.Errorf("envelope from %q", msg.from)
+	}
+
+	if len(msg.to) != 1 || msg.to[0] != "jane@example.com" {
+		t.Errorf("envelope to %q", msg.to)
+	}
+
+	e, err := mail.ReadMessage(bytes.NewReader(msg.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{
+		"From":         "showcase@example.com",
+		"To":           "jane@example.com",
+		"Mime-Version": "1.0",
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+
+	for k, want := range headers {
+		if got := e.Header.Get(k); got != want {
+			t.Errorf("%s header %q, want %q", k, got, want)
+		}
+	}
+
+	got, err := new(mime.WordDecoder).DecodeHeader(e.Header.Get("Subject"))
+	if err != nil || got != subject {
+		t.Errorf("subject %q (%v), want %q", got, err, subject)
+	}
+
+	if _, err := e.Header.Date(); err != nil {
+		t.Errorf("date header: %v", err)
+	}
+
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReadDotBytes undoes the dot stuffing and CRLF line endings of the
+	// wire, the lone dot must come through as it was.
+	want := body + "\n"
+	if string(b) != want {
+		t.Errorf("body %q, want %q", b, want)
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	s := newSMTPStub(t)
+
+	m, err := NewSMTPMailer("smtp://"+s.l.Addr().String(), "showcase@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		to, subject string
+	}{
+		{"jane@example.com\r\nBcc: eve@example.com", "Reset"},
+		{"jane@example.com\nBcc: eve@example.com", "Reset"},
+		{"jane@example.com", "Reset\r\nBcc: eve@example.com"},
+		{"jane@example.com", "Reset\rBcc: eve@example.com"},
+	}
+
+	for _, c := range cases {
+		if err := m.Send(c.to, c.subject, "body"); err != ErrInvalidMailHeader {
+			t.Errorf("Send(%q, %q) = %v, want %v", c.to, c.subject, err, ErrInvalidMailHeader)
+		}
+	}
+
+	if n := atomic.LoadInt32(&s.conns); n != 0 {
+		t.Errorf("%d connections to the server, want none", n)
+	}
+}
+
+func TestNewSMTPMailerRejectsOtherSchemes(t *testing.T) {
+	for _, addr := range []string{"smtps://mail.example.com:465", "mail.example.com:25", "smtp://"} {
+		if _, err := NewSMTPMailer(addr, "showcase@example.com"); err == nil {
+			t.Errorf("NewSMTPMailer(%q) succeeded", addr)
+		}
+	}
+}
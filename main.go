@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,50 +17,231 @@ import (
 )
 
 const (
+	MemoryCache    string        = "memory"
+	LRUCache       string        = "lru"
+	RedisCache     string        = "redis"
+	BoltDriver     string        = "bolt"
 	DatabasePath   string        = "database/showcase.db"
 	DefaultTimeout time.Duration = 15 * time.Second
 )
 
 func main() {
 	var (
-		httpAddr  = flag.String("http.addr", ":8080", "HTTP listen address")
-		debugMode = flag.Bool("debug", false, "Debug mode")
+		httpAddr   = flag.String("http.addr", ":8080", "HTTP listen address")
+		debugMode  = flag.Bool("debug", false, "Debug mode")
+		dryRun     = flag.Bool("migrate.dry-run", false, "Run pending database migrations without committing them and exit")
+		exportTo   = flag.String("export", "", "Export the site into an archive at this path and exit")
+		importFrom = flag.String("import", "", "Import the site from an archive at this path and exit")
+		fsck       = flag.Bool("fsck", false, "Check the database and media for inconsistencies and exit")
+		fsckRepair = flag.Bool("fsck.repair", false, "Let -fsck repair the problems that are safe to fix")
+
+		dbDriver = flag.String("db.driver", BoltDriver, "Storage backend, either bolt or sqlite")
+		dbPath   = flag.String("db.path", "", "Database file, defaults to "+DatabasePath+" or "+SqliteDatabasePath)
+		toSqlite = flag.String("migrate.to-sqlite", "", "Copy the bolt database into a new SQLite database at this path and exit")
+
+		cacheKind    = flag.String("cache", MemoryCache, "Cache implementation, either memory, lru or redis")
+		cacheEntries = flag.Int("cache.entries", LRUCacheEntries, "Maximum number of entries held by the lru cache, 0 is unlimited")
+		cacheBytes   = flag.Int64("cache.bytes", LRUCacheBytes, "Approximate number of bytes held by the lru cache, 0 is unlimited")
+		cacheTTL     = flag.Duration("cache.ttl", DefaultExpiration, "Time an entry stays in the cache unless given its own")
+		cacheRedis   = flag.String("cache.redis", RedisCacheAddr, "Address of the redis cache as redis://[:password@]host:port/db")
+		cachePrefix  = flag.String("cache.redis-prefix", RedisCachePrefix, "Prefix of the keys stored in the redis cache")
+		cacheStale   = flag.Bool("cache.stale", false, "Serve expired cache entries while a single request refreshes them")
+
+		historyLimit = flag.Int("history.limit", DefaultHistoryLimit, "Number of revisions kept per project or page, 0 keeps all")
+
+		trashRetention = flag.Duration("trash.retention", TrashRetention, "Time deleted projects and pages stay in the trash, 0 keeps them until purged")
+
+		auditRetention = flag.Duration("audit.retention", AuditRetention, "Time admin actions stay in the audit log, 0 keeps them forever")
+		auditReads     = flag.Bool("audit.reads", true, "Record reads of protected admin routes, exports and backups are recorded either way")
+
+		mediaGrace      = flag.Duration("media.grace", MediaGracePeriod, "Age an unreferenced media file must reach before it is collected")
+		mediaQuarantine = flag.String("media.quarantine", QuarantinePath, "Directory collected media files are moved to, empty deletes them")
+		mediaInterval   = flag.Duration("media.gc-interval", MediaCollectorInterval, "Interval between media collections, 0 disables them")
+
+		backupDir      = flag.String("backup.dir", BackupPath, "Directory for scheduled database backups")
+		backupInterval = flag.Duration("backup.interval", BackupInterval, "Interval between scheduled backups, 0 disables them")
+		backupDaily    = flag.Int("backup.daily", 7, "Number of daily backups to keep")
+		backupWeekly   = flag.Int("backup.weekly", 4, "Number of weekly backups to keep")
+
+		accessTTL  = flag.Duration("auth.access-ttl", AccessTokenLifetime, "Time an admin access token is accepted")
+		refreshTTL = flag.Duration("auth.refresh-ttl", RefreshTokenLifetime, "Time an admin session may stay unused before it must sign in again, 0 keeps it until revoked")
+
+		loginMax     = flag.Int("login.max-attempts", LoginMaxAttempts, "Failed sign ins per email before it is locked out, 0 never locks it")
+		loginIPMax   = flag.Int("login.ip-max-attempts", LoginIPMaxAttempts, "Failed sign ins per IP address before it is locked out, 0 never locks it")
+		loginBackoff = flag.Duration("login.backoff", LoginBackoff, "Wait after the first failed sign in, doubled by every further failure")
+		loginLockout = flag.Duration("login.lockout", LoginLockout, "Time a lockout lasts and failed sign ins are remembered")
+		unlock       = flag.String("unlock", "", "Lift the login lockout of an email or IP address, or of everyone with all, and exit")
+
+		mailSMTP   = flag.String("mail.smtp", "", "Mail server as smtp://[user:password@]host:port, password reset emails are not sent without one")
+		mailFrom   = flag.String("mail.from", "showcase@localhost", "Sender address of emails")
+		resetURL   = flag.String("mail.reset-url", "", "Page password reset emails link to, the token is appended as ?token=")
+		resetEmail = flag.String("reset-password", "", "Give the account with this email a new generated password, sign it out everywhere and exit")
+
+		corsOrigins     = flag.String("cors.origins", "", "Comma separated origins, or patterns such as https://*.example.com, allowed to call the admin API from a browser")
+		corsMethods     = flag.String("cors.methods", CorsMethods, "Comma separated methods allowed in cross-origin requests")
+		corsHeaders     = flag.String("cors.headers", CorsHeaders, "Comma separated headers allowed in cross-origin requests")
+		corsCredentials = flag.Bool("cors.credentials", false, "Allow cross-origin requests to send cookies and credentials")
+		corsMaxAge      = flag.Duration("cors.max-age", CorsMaxAge, "Time browsers may cache the answer to a preflight request")
+
+		cspMode      = flag.String("csp", CSPEnforce, "Content-Security-Policy of public pages, either enforce, report-only or off")
+		cspReportURI = flag.String("csp.report-uri", "", "Address browsers report Content-Security-Policy violations to")
+		referrer     = flag.String("headers.referrer-policy", ReferrerPolicy, "Referrer-Policy of public pages, empty sends none")
+		permissions  = flag.String("headers.permissions-policy", PermissionsPolicy, "Permissions-Policy of public pages, empty sends none")
+		hsts         = flag.Duration("headers.hsts", HSTSMaxAge, "Max age of the Strict-Transport-Security header sent over TLS, 0 sends none")
 	)
 	flag.Parse()
 
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	bolt, err := bolt.Open(DatabasePath, 0600, nil)
+	var ctx = context.TODO()
+
+	cache, err := newCache(*cacheKind, *cacheEntries, *cacheBytes, *cacheTTL, *cacheRedis, *cachePrefix, logger)
 	if err != nil {
 		panic(err)
 	}
-	defer bolt.Close()
 
-	var (
-		ctx   = context.TODO()
-		cache = NewMemoryCache(DefaultExpiration, DefaultEvictionInterval)
-		db    = NewCachedDatabase(bolt, cache, logger)
-	)
+	options := DatabaseOptions{
+		HistoryLimit:         *historyLimit,
+		StaleWhileRevalidate: *cacheStale,
+	}
+
+	st, err := openStorage(*dbDriver, *dbPath, cache, options, logger)
+	if err != nil {
+		panic(err)
+	}
+	defer st.Close()
+
+	err = st.migrator.Migrate(*dryRun)
+	if err != nil {
+		panic(err)
+	}
+
+	if *dryRun {
+		return
+	}
+
+	db := st.db
 
 	c, err := db.Setup(ctx)
 	if err != nil {
 		panic(err)
 	}
 
+	if *toSqlite != "" {
+		err = migrateToSqlite(ctx, db, *toSqlite, options, logger)
+		if err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	if *fsck {
+		problems, err := NewChecker(db, logger).Check(ctx, *fsckRepair)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, p := range problems {
+			logger.Warn("fsck",
+				zap.String("problem", string(p.Type)),
+				zap.String("subject", p.Subject),
+				zap.String("detail", p.Detail),
+				zap.Bool("repaired", p.Repaired))
+		}
+
+		logger.Info("app", zap.String("event", "check completed"), zap.Int("problems", len(problems)))
+
+		return
+	}
+
+	if *unlock != "" {
+		err = unlockLogin(db, *unlock)
+		if err != nil {
+			panic(err)
+		}
+
+		logger.Info("app", zap.String("event", "login unlocked"), zap.String("target", *unlock))
+
+		return
+	}
+
+	if *resetEmail != "" {
+		password, err := resetPassword(ctx, db, *resetEmail)
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("New password of %s: %s\n", *resetEmail, password)
+
+		return
+	}
+
+	if *exportTo != "" || *importFrom != "" {
+		archiver := NewArchiver(db, NewMediaManager(cache), logger)
+
+		if *exportTo != "" {
+			err = archiver.ExportFile(ctx, *exportTo)
+		} else {
+			_, err = archiver.ImportFile(ctx, *importFrom)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
 	var (
 		composer     = NewComposer(db, logger)
 		renderer     = NewRenderer()
 		manager      = NewMediaManager(cache)
 		builder      = NewSitemapBuiler(db, logger, SitemapInterval)
+		backups      = NewBackupScheduler(st.snapshotter, logger, *backupDir, *backupInterval, RetentionPolicy{Daily: *backupDaily, Weekly: *backupWeekly})
+		trash        = NewTrashCollector(db, manager, logger, *trashRetention)
+		audit        = NewAuditLog(db, logger, *auditRetention, *auditReads)
+		collector    = NewMediaCollector(db, manager, logger, *mediaGrace, *mediaQuarantine, *mediaInterval)
+		publisher    = NewPublisher(db, logger, PublishInterval)
 		configurator = NewConfigurator(composer, renderer, manager, builder)
-		finalizer    = NewFinalizer(cache, builder)
+		finalizer    = NewFinalizer(cache, builder, publisher)
 	)
 	defer finalizer.Finalize()
 
+	mailer, err := NewMailer(*mailSMTP, *mailFrom, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	auth := AuthOptions{
+		AccessTokenLifetime:  *accessTTL,
+		RefreshTokenLifetime: *refreshTTL,
+		Login: LoginPolicy{
+			MaxAttempts:   *loginMax,
+			IPMaxAttempts: *loginIPMax,
+			Backoff:       *loginBackoff,
+			Lockout:       *loginLockout,
+		},
+		PasswordResetURL: *resetURL,
+	}
+
+	logins := NewLoginSweeper(db, logger, auth.Login)
+
+	cors, err := NewCorsPolicy(*corsOrigins, *corsMethods, *corsHeaders, *corsCredentials, *corsMaxAge)
+	if err != nil {
+		panic(err)
+	}
+
+	security, err := NewSecurityOptions(*cspMode, *cspReportURI, *referrer, *permissions, *hsts)
+	if err != nil {
+		panic(err)
+	}
+
 	var (
-		server = NewServer(configurator, db, cache, composer, renderer, manager, logger)
-		c0, c1 = configurator.Configure(c)
+		archiver = NewArchiver(db, manager, logger)
+		server   = NewServer(configurator, db, cache, composer, renderer, manager, archiver, backups, trash, collector, mailer, audit, auth, cors, security, logger)
+		c0, c1   = configurator.Configure(c)
 	)
 
 	go func() {
@@ -78,6 +260,33 @@ func main() {
 	<-c0
 
 	go builder.Run()
+	go publisher.Run()
+
+	if *backupInterval > 0 {
+		go backups.Run()
+		finalizer.Append(backups)
+	}
+
+	if *trashRetention > 0 {
+		go trash.Run()
+		finalizer.Append(trash)
+	}
+
+	if *auditRetention > 0 {
+		go audit.Run()
+		finalizer.Append(audit)
+	}
+
+	if *loginLockout > 0 {
+		go logins.Run()
+		finalizer.Append(logins)
+	}
+
+	if *mediaInterval > 0 {
+		go collector.Run()
+		finalizer.Append(collector)
+	}
+
 	go manager.PopulateEtagCache()
 
 	var (
@@ -134,3 +343,110 @@ func main() {
 
 	logger.Warn("app", zap.String("event", "terminating"), zap.Error(<-errs))
 }
+
+func newCache(kind string, entries int, bytes int64, ttl time.Duration, addr string, prefix string, logger *zap.Logger) (Cache, error) {
+	switch kind {
+	case MemoryCache:
+		return NewMemoryCache(ttl, DefaultEvictionInterval), nil
+	case LRUCache:
+		return NewLRUCache(entries, bytes, ttl, DefaultEvictionInterval), nil
+	case RedisCache:
+		return NewRedisCache(addr, prefix, ttl, logger)
+	}
+
+	return nil, ErrUnknownCache
+}
+
+type storage struct {
+	db          DB
+	migrator    *Migrator
+	snapshotter Snapshotter
+
+	io.Closer
+}
+
+func openStorage(driver string, path string, cache Cache, options DatabaseOptions, logger *zap.Logger) (*storage, error) {
+	switch driver {
+	case BoltDriver:
+		if path == "" {
+			path = DatabasePath
+		}
+
+		// Fail instead of waiting forever if another process, e.g. a
+		// running server, holds the database lock.
+		b, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage{
+			db:          NewCachedDatabase(b, cache, logger, options),
+			migrator:    NewMigrator(b, logger),
+			snapshotter: NewBoltSnapshotter(b),
+			Closer:      b,
+		}, nil
+	case SqliteDriver:
+		if path == "" {
+			path = SqliteDatabasePath
+		}
+
+		s, err := OpenSqlite(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &storage{
+			db:          NewSqliteDatabase(s, cache, logger, options),
+			migrator:    NewSqlMigrator(s, logger),
+			snapshotter: NewSqliteSnapshotter(s),
+			Closer:      s,
+		}, nil
+	}
+
+	return nil, ErrUnknownDriver
+}
+
+// migrateToSqlite copies the current database into a new SQLite database.
+// The target must not exist yet, so an existing site is never overwritten.
+// The copy is built next to it and only moved into place once complete, so
+// a failed copy can simply be retried.
+func migrateToSqlite(ctx context.Context, from DB, path string, options DatabaseOptions, logger *zap.Logger) error {
+	if _, err := os.Stat(path); err == nil {
+		return os.ErrExist
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+
+	if err := copyToSqlite(ctx, from, tmp, options, logger); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	logger.Info("app", zap.String("event", "database copied"), zap.String("path", path))
+
+	return nil
+}
+
+func copyToSqlite(ctx context.Context, from DB, path string, options DatabaseOptions, logger *zap.Logger) error {
+	st, err := openStorage(SqliteDriver, path, NewMemoryCache(DefaultExpiration, DefaultEvictionInterval), options, logger)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if err = st.migrator.Migrate(false); err != nil {
+		return err
+	}
+
+	if _, err = st.db.Setup(ctx); err != nil {
+		return err
+	}
+
+	return CopyDatabase(ctx, from, st.db)
+}
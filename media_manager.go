@@ -81,11 +81,58 @@ func (mm *MediaManager) Delete(m *Media) error {
 		return err
 	}
 
+	mm.ca.Delete(filepath.Base(m.Path))
+
 	m = &Media{}
 
 	return nil
 }
 
+// Quarantine moves a media file below dir, keeping its folder, instead of
+// deleting it.
+func (mm *MediaManager) Quarantine(p string, dir string) error {
+	q := filepath.Join(dir, filepath.Base(filepath.Dir(p)))
+	if err := os.MkdirAll(q, 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(p, filepath.Join(q, filepath.Base(p))); err != nil {
+		return err
+	}
+
+	mm.ca.Delete(filepath.Base(p))
+
+	return nil
+}
+
+// Place moves a file into the media directory under the given name. If a
+// different file already exists under that name, a fresh name is chosen.
+// The resulting media path is returned.
+func (mm *MediaManager) Place(src string, dir string, name string) (string, error) {
+	h, err := hashFile(src)
+	if err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(MediaPath, dir, filepath.Base(name))
+
+	if e, err := hashFile(p); err == nil {
+		if e == h {
+			return p, os.Remove(src)
+		}
+
+		p = filepath.Join(MediaPath, dir, tempName("m-", filepath.Ext(name)))
+	}
+
+	if err := os.Rename(src, p); err != nil {
+		return "", err
+	}
+
+	mm.ca.Set(filepath.Base(p), h)
+
+	return p, nil
+}
+
 func (mm *MediaManager) PopulateEtagCache() {
 	go providePopulatingFunc(MediaImage, sha256.New(), mm.ca)()
 	go providePopulatingFunc(MediaVideo, sha256.New(), mm.ca)()
@@ -112,6 +159,40 @@ func providePopulatingFunc(t MediaType, h hash.Hash, c Cache) func() {
 	}
 }
 
+func hashFile(p string) (string, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	hasher.Write(data)
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func userMedia(u *User) []*Media {
+	return []*Media{&u.Image, &u.Logo}
+}
+
+func projectMedia(p *Project) []*Media {
+	m := []*Media{&p.Image, &p.Logo, &p.Client.Image}
+	for i := range p.Images {
+		m = append(m, &p.Images[i])
+	}
+
+	return m
+}
+
+func contentMedia(c *Content) []*Media {
+	m := make([]*Media, 0, len(c.Paragraphs))
+	for i := range c.Paragraphs {
+		m = append(m, &c.Paragraphs[i].Media)
+	}
+
+	return m
+}
+
 func tempName(prefix, suffix string) string {
 	return prefix + uuid.New().String() + suffix
 }
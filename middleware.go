@@ -26,6 +26,10 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+func (w gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func NewLoggingMiddleware(logger *zap.Logger) Middleware {
 	return func(next HandleFunc) HandleFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
@@ -38,16 +42,40 @@ func NewLoggingMiddleware(logger *zap.Logger) Middleware {
 	}
 }
 
-func NewCorsMiddleware() Middleware {
+// NewCorsMiddleware applies a CORS policy. Preflights are answered here
+// with 204, requests from origins the policy does not allow are refused.
+// Requests without an Origin, or from the origin serving them, pass as they
+// are.
+func NewCorsMiddleware(p CorsPolicy) Middleware {
 	return func(next HandleFunc) HandleFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+			w.Header().Add("Vary", "Origin")
 
-			if r.Method == "OPTIONS" {
-				w.Write([]byte("{}"))
+			origin := r.Header.Get("Origin")
+			if origin == "" || sameOrigin(r, origin) {
+				if r.Method == "OPTIONS" {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				next(w, r)
+				return
+			}
+
+			if !p.allowsOrigin(origin) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
 
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				p.preflight(w, r, origin)
+				return
+			}
+
+			p.allow(w, origin)
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusNoContent)
 				return
 			}
 
@@ -56,6 +84,38 @@ func NewCorsMiddleware() Middleware {
 	}
 }
 
+// NewSecurityHeadersMiddleware sets the security headers of public pages.
+// Each request gets a fresh nonce for the theme's Content-Security-Policy,
+// which is passed on in the request context for the page's inline scripts.
+func NewSecurityHeadersMiddleware(o SecurityOptions, r *Renderer) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+
+			if o.ReferrerPolicy != "" {
+				w.Header().Set("Referrer-Policy", o.ReferrerPolicy)
+			}
+
+			if o.PermissionsPolicy != "" {
+				w.Header().Set("Permissions-Policy", o.PermissionsPolicy)
+			}
+
+			if o.HSTSMaxAge > 0 && servedOverTLS(req) {
+				w.Header().Set("Strict-Transport-Security", hstsValue(o.HSTSMaxAge))
+			}
+
+			if h := o.cspHeader(); h != "" {
+				nonce := NewCSPNonce()
+				w.Header().Set(h, r.CSP().String(nonce, o.CSPReportURI))
+
+				req = req.WithContext(withCSPNonce(req.Context(), nonce))
+			}
+
+			next(w, req)
+		}
+	}
+}
+
 func NewJsonMiddleware() Middleware {
 	return func(next HandleFunc) HandleFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
@@ -87,44 +147,139 @@ func NewGzipMiddleware(gp *fs.GzipPool) Middleware {
 	}
 }
 
-func NewAuthorisationMiddleware(c Cache, signingFunc func(token *jwt.Token) (interface{}, error)) Middleware {
+// NewAuthorisationMiddleware admits requests carrying an unexpired access
+// token of a live session, or an API key granted the route's scope. Either
+// way the role of the account behind it must allow the route. The account,
+// and the session or key, are passed on in the request context. Bad
+// credentials are answered with 401, a role or scope denial with 403.
+func NewAuthorisationMiddleware(db DB, role Role, scope Scope, signingFunc func(token *jwt.Token) (interface{}, error)) Middleware {
 	return func(next HandleFunc) HandleFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
+			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, APIKeyPrefix) {
+				k, ok := authenticateAPIKey(r.Context(), db, auth)
+				if !ok {
+					refuseToken(w)
+					return
+				}
+
+				if scope == "" || !k.Allows(scope) {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+
+				a, err := db.GetAccount(r.Context(), k.Account)
+				if err != nil {
+					refuseToken(w)
+					return
+				}
+
+				if !a.Role.Allows(role) {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+
+				if now := time.Now(); now.Sub(k.LastUsed) > SessionTouchInterval {
+					// A failed touch only leaves the last-used time behind.
+					if db.TouchAPIKey(k.ID, now) == nil {
+						k.LastUsed = now
+					}
+				}
+
+				ctx := withAPIKey(withAccount(r.Context(), a), k)
+
+				next(w, r.WithContext(ctx))
+				return
+			}
+
 			if auth := r.Header.Get("Authorization"); auth != "" {
 				token, err := jwt.Parse(auth, signingFunc)
 				if err != nil {
-					http.Error(w, "unauthorized", http.StatusForbidden)
+					refuseToken(w)
 					return
 				}
 
-				if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-					if t, ok := c.Get("token"); ok {
-						if t == claims["token"] {
-							next(w, r)
+				if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid && claims.VerifyExpiresAt(time.Now().Unix(), true) {
+					var (
+						id, _  = claims["session"].(string)
+						sub, _ = claims["sub"].(string)
+					)
+
+					if sn, err := db.GetSession(r.Context(), id); err == nil && id != "" && sn.Account == sub {
+						a, err := db.GetAccount(r.Context(), sn.Account)
+						if err != nil {
+							refuseToken(w)
 							return
 						}
 
-						http.Error(w, "unauthorized", http.StatusForbidden)
-						return
+						if !a.Role.Allows(role) {
+							http.Error(w, "forbidden", http.StatusForbidden)
+							return
+						}
+
+						if now := time.Now(); now.Sub(sn.LastSeen) > SessionTouchInterval {
+							// A failed touch only leaves the last-seen time behind.
+							if db.TouchSession(sn.ID, now) == nil {
+								sn.LastSeen = now
+							}
+						}
 
+						ctx := withSession(withAccount(r.Context(), a), sn)
+
+						next(w, r.WithContext(ctx))
+						return
 					}
 
-					http.Error(w, "unauthorized", http.StatusForbidden)
+					refuseToken(w)
 					return
 
 				}
 
-				http.Error(w, "unauthorized", http.StatusForbidden)
+				refuseToken(w)
 				return
 
 			}
 
-			http.Error(w, "unauthorized", http.StatusForbidden)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
 	}
 }
 
+// refuseToken answers a request whose token or API key is invalid, expired
+// or revoked. Clients refresh their session on invalid_token only, a 403
+// means the account is not allowed the route and a new token won't help.
+func refuseToken(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// NewAuditMiddleware records each request to an admin route in the audit
+// log, with who made it, what it acted on, the fields it set and whether it
+// failed. It runs after authorisation, which supplies the actor.
+func NewAuditMiddleware(l *AuditLog, path string) Middleware {
+	action := auditAction(path)
+
+	return func(next HandleFunc) HandleFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var (
+				e      = newAuditEntry(r, action)
+				fields = readAuditFields(r)
+				aw     = &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			)
+
+			next(aw, r)
+
+			e.Target = auditTarget(r, fields)
+			e.Changes = auditChanges(fields)
+			e.Status = aw.status
+			e.Error = aw.failure()
+
+			l.Record(e)
+		}
+	}
+}
+
 type cacheHandler struct {
 	h http.Handler
 	c Cache
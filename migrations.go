@@ -0,0 +1,449 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_META string = "meta"
+
+	SchemaVersionKey string = "schema-version"
+)
+
+// Migration describes a single, ordered change of the stored schema.
+// Migrations are applied exactly once, in ascending Version order, and
+// must only operate on the raw JSON so they don't depend on the current
+// shape of the models. Migrate is applied to bolt databases, MigrateSql
+// to SQLite databases; a nil function is a no-op for that backend.
+type Migration struct {
+	Version     uint64
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+	MigrateSql  func(tx *sql.Tx) error
+}
+
+// Migrations holds every schema change known to this binary. New entries
+// must be appended with the next version number and never reordered.
+var Migrations = []Migration{
+	Migration{
+		Version:     1,
+		Description: "record schema version",
+	},
+	Migration{
+		Version:     2,
+		Description: "add publication status to projects and content",
+		Migrate: func(tx *bolt.Tx) error {
+			for _, b := range []string{BUCKET_PROJECTS, BUCKET_CONTENT} {
+				err := migrateRecords(tx, b, func(k []byte, r map[string]interface{}) error {
+					if _, ok := r["Status"]; !ok {
+						r["Status"] = string(StatusPublished)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		MigrateSql: func(tx *sql.Tx) error {
+			for _, t := range []string{"projects", "contents"} {
+				_, err := tx.Exec(`UPDATE `+t+` SET data = json_set(data, '$.Status', ?) WHERE json_extract(data, '$.Status') IS NULL`, string(StatusPublished))
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	Migration{
+		Version:     3,
+		Description: "add project positions and project order",
+		Migrate: func(tx *bolt.Tx) error {
+			var i int
+			err := migrateRecords(tx, BUCKET_PROJECTS, func(k []byte, r map[string]interface{}) error {
+				if _, ok := r["Position"]; !ok {
+					r["Position"] = i
+				}
+				i++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return migrateRecord(tx, BUCKET_COMMON, "configuration", func(r map[string]interface{}) error {
+				if _, ok := r["ProjectOrder"]; !ok {
+					r["ProjectOrder"] = string(ProjectOrderManual)
+				}
+				return nil
+			})
+		},
+		MigrateSql: func(tx *sql.Tx) error {
+			var slugs []string
+
+			rows, err := tx.Query(`SELECT slug FROM projects ORDER BY slug`)
+			if err != nil {
+				return err
+			}
+
+			for rows.Next() {
+				var slug string
+				if err = rows.Scan(&slug); err != nil {
+					break
+				}
+				slugs = append(slugs, slug)
+			}
+
+			if err == nil {
+				err = rows.Err()
+			}
+			rows.Close()
+
+			if err != nil {
+				return err
+			}
+
+			for i, slug := range slugs {
+				_, err := tx.Exec(`UPDATE projects SET data = json_set(data, '$.Position', ?) WHERE slug = ? AND json_extract(data, '$.Position') IS NULL`, i, slug)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.Exec(`UPDATE common SET value = json_set(value, '$.ProjectOrder', ?) WHERE key = 'configuration' AND json_extract(value, '$.ProjectOrder') IS NULL`, string(ProjectOrderManual))
+			return err
+		},
+	},
+	Migration{
+		Version:     4,
+		Description: "move the single login into an owner account",
+		Migrate: func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(BUCKET_COMMON))
+			if b == nil {
+				return nil
+			}
+
+			v := b.Get([]byte("credentials"))
+			if v == nil {
+				return nil
+			}
+
+			var c map[string]interface{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+
+			ab, err := tx.CreateBucketIfNotExists([]byte(BUCKET_ACCOUNTS))
+			if err != nil {
+				return err
+			}
+
+			if a := ownerAccount(c); a != nil {
+				if err := save(ab, []byte(a["ID"].(string)), a); err != nil {
+					return err
+				}
+			}
+
+			return b.Delete([]byte("credentials"))
+		},
+		MigrateSql: func(tx *sql.Tx) error {
+			var v string
+			err := tx.QueryRow(`SELECT value FROM common WHERE key = 'credentials'`).Scan(&v)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			var c map[string]interface{}
+			if err := json.Unmarshal([]byte(v), &c); err != nil {
+				return err
+			}
+
+			if a := ownerAccount(c); a != nil {
+				data, err := json.Marshal(a)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(`INSERT INTO accounts (id, email, data) VALUES (?, ?, ?)`, a["ID"], a["Email"], string(data))
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.Exec(`DELETE FROM common WHERE key = 'credentials'`)
+			return err
+		},
+	},
+}
+
+// ownerAccount turns the raw single login of schema version 3 into an owner
+// account, or returns nil if setup never stored one.
+func ownerAccount(credentials map[string]interface{}) map[string]interface{} {
+	email, _ := credentials["Email"].(string)
+	if email == "" {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"ID":      uuid.New().String(),
+		"Email":   normalizeEmail(email),
+		"Name":    "",
+		"Hash":    credentials["Hash"],
+		"Role":    string(RoleOwner),
+		"Created": time.Now(),
+	}
+}
+
+// SchemaVersion is the schema version written by this binary.
+func SchemaVersion() uint64 {
+	if len(Migrations) == 0 {
+		return 0
+	}
+
+	return Migrations[len(Migrations)-1].Version
+}
+
+type Migrator struct {
+	bolt   *bolt.DB
+	sql    *sql.DB
+	logger *zap.Logger
+}
+
+func NewMigrator(bolt *bolt.DB, logger *zap.Logger) *Migrator {
+	return &Migrator{
+		bolt:   bolt,
+		logger: logger,
+	}
+}
+
+func NewSqlMigrator(db *sql.DB, logger *zap.Logger) *Migrator {
+	return &Migrator{
+		sql:    db,
+		logger: logger,
+	}
+}
+
+// Migrate applies every pending migration in a single transaction. In dry
+// run mode the migrations are executed and logged, but the transaction is
+// rolled back. A database written by a newer binary is never touched.
+func (m *Migrator) Migrate(dryRun bool) error {
+	if m.sql != nil {
+		return m.migrateSql(dryRun)
+	}
+
+	tx, err := m.bolt.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	applied, err := m.apply(readSchemaVersion(tx), dryRun, func(v Migration) error {
+		if v.Migrate != nil {
+			if err := v.Migrate(tx); err != nil {
+				return err
+			}
+		}
+
+		return writeSchemaVersion(tx, v.Version)
+	})
+
+	if err != nil || applied == 0 || dryRun {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) migrateSql(dryRun bool) error {
+	tx, err := m.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = createSqliteTables(tx); err != nil {
+		return err
+	}
+
+	current, err := readSqlSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.apply(current, dryRun, func(v Migration) error {
+		if v.MigrateSql != nil {
+			if err := v.MigrateSql(tx); err != nil {
+				return err
+			}
+		}
+
+		return writeSqlSchemaVersion(tx, v.Version)
+	})
+
+	if err != nil || dryRun {
+		return err
+	}
+
+	// The tables created above are committed even if nothing was migrated.
+	return tx.Commit()
+}
+
+// apply runs every migration newer than the current version in order.
+func (m *Migrator) apply(current uint64, dryRun bool, fn func(Migration) error) (int, error) {
+	if current > SchemaVersion() {
+		m.logger.Error("database schema is newer than supported",
+			zap.Uint64("database", current),
+			zap.Uint64("supported", SchemaVersion()))
+		return 0, ErrSchemaTooNew
+	}
+
+	var applied int
+	for _, v := range Migrations {
+		if v.Version <= current {
+			continue
+		}
+
+		m.logger.Info("migration",
+			zap.Uint64("version", v.Version),
+			zap.String("description", v.Description),
+			zap.Bool("dry-run", dryRun))
+
+		if err := fn(v); err != nil {
+			m.logger.Error("migration failed", zap.Uint64("version", v.Version), zap.Error(err))
+			return applied, err
+		}
+
+		applied++
+	}
+
+	if applied == 0 {
+		m.logger.Info("migration", zap.String("event", "schema up to date"), zap.Uint64("version", current))
+	}
+
+	return applied, nil
+}
+
+func readSchemaVersion(tx *bolt.Tx) uint64 {
+	b := tx.Bucket([]byte(BUCKET_META))
+	if b == nil {
+		return 0
+	}
+
+	v := b.Get([]byte(SchemaVersionKey))
+	if len(v) != 8 {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(v)
+}
+
+func writeSchemaVersion(tx *bolt.Tx, version uint64) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_META))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, version)
+
+	return b.Put([]byte(SchemaVersionKey), buf)
+}
+
+func readSqlSchemaVersion(tx *sql.Tx) (uint64, error) {
+	var v string
+	err := tx.QueryRow(`SELECT value FROM meta WHERE key = ?`, SchemaVersionKey).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(v, 10, 64)
+}
+
+func writeSqlSchemaVersion(tx *sql.Tx, version uint64) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)`, SchemaVersionKey, strconv.FormatUint(version, 10))
+	return err
+}
+
+// migrateRecords rewrites every JSON record stored in a bucket. The
+// record is decoded into a generic map, so fields can be added, renamed
+// or backfilled without relying on the current model types. Buckets that
+// don't exist yet are skipped.
+func migrateRecords(tx *bolt.Tx, bucket string, fn func(k []byte, r map[string]interface{}) error) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		var r map[string]interface{}
+		if err := json.Unmarshal(b.Get(k), &r); err != nil {
+			return err
+		}
+
+		if err := fn(k, r); err != nil {
+			return err
+		}
+
+		if err := save(b, k, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateRecord rewrites a single JSON record stored under a key. Missing
+// buckets and keys are skipped.
+func migrateRecord(tx *bolt.Tx, bucket string, key string, fn func(r map[string]interface{}) error) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+
+	var r map[string]interface{}
+	if err := json.Unmarshal(v, &r); err != nil {
+		return err
+	}
+
+	if err := fn(r); err != nil {
+		return err
+	}
+
+	return save(b, []byte(key), r)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"time"
 )
@@ -29,7 +30,9 @@ type Project struct {
 
 	Title, Subtitle, About string
 	Image, Logo            Media
-	Published              time.Time
+	Status                 Status
+	Published, PublishAt   time.Time
+	Position               int
 	Images                 []Media
 	Tags                   []Tag
 	Technologies           []Technology
@@ -42,12 +45,13 @@ type Project struct {
 type Content struct {
 	Slug string
 
-	Title, Subtitle string
-	Published       time.Time
-	Paragraphs      []Paragraph
-	Tags            []Tag
-	Technologies    []Technology
-	References      Map
+	Title, Subtitle      string
+	Status               Status
+	Published, PublishAt time.Time
+	Paragraphs           []Paragraph
+	Tags                 []Tag
+	Technologies         []Technology
+	References           Map
 }
 
 type Menu map[int]Route
@@ -100,10 +104,140 @@ type Imported struct {
 	Date time.Time
 }
 
+// Credentials is the single login of schema version 3 and older, it is
+// only read to import old archives.
 type Credentials struct {
 	Email, Hash string
 }
 
+type Account struct {
+	ID          string
+	Email, Name string
+	Hash        string
+	Role        Role
+	Created     time.Time
+
+	// TOTP is the second factor of the account, set once enrollment
+	// started.
+	TOTP *TOTP `json:",omitempty"`
+}
+
+type TOTP struct {
+	Secret  string
+	Enabled bool
+
+	// LastStep is the time step of the last code accepted.
+	LastStep int64
+
+	// Recovery holds the hashes of the unused recovery codes.
+	Recovery []string
+}
+
+type Session struct {
+	ID        string
+	Account   string
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+
+	// Generation counts the refresh token rotations of the session.
+	Generation uint64
+}
+
+type APIKey struct {
+	ID       string
+	Account  string
+	Name     string
+	Hash     string
+	Scopes   []Scope
+	Created  time.Time
+	LastUsed time.Time
+}
+
+type PasswordReset struct {
+	Account string
+	Hash    string
+	Created time.Time
+	Expires time.Time
+}
+
+type AuditEntry struct {
+	ID      uint64
+	Time    time.Time
+	Actor   string
+	APIKey  string
+	Action  string
+	Target  string
+	IP      string
+	Changes []string
+	Status  int
+	Error   string
+}
+
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Before uint64
+	Limit  int
+}
+
+type LoginAttempts struct {
+	Key         string
+	Failures    int
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+type Revision struct {
+	ID      uint64
+	Kind    RevisionKind
+	Slug    string
+	Author  string
+	Created time.Time
+	Data    json.RawMessage
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type Redirect struct {
+	Kind     RevisionKind
+	From, To string
+	Created  time.Time
+}
+
+type TrashItem struct {
+	ID           uint64
+	Kind         RevisionKind
+	Slug, Title  string
+	Deleted      time.Time
+	Route        *Route
+	InMenu       bool
+	MenuPosition int
+	Media        []string
+	Data         json.RawMessage
+}
+
+type Problem struct {
+	Type     ProblemType
+	Subject  string
+	Detail   string
+	Repaired bool
+}
+
+type MediaCollection struct {
+	Files      []string
+	Bytes      int64
+	Quarantine string
+}
+
 type ProjectStatistics struct {
 	Views, Likes uint64
 }
@@ -115,6 +249,7 @@ type Configuration struct {
 	CurrentThemePath string
 	CurrentTheme     Theme
 	Meta             Meta
+	ProjectOrder     ProjectOrder
 }
 
 type Theme struct {
@@ -124,8 +259,12 @@ type Theme struct {
 	Image       string   `json:"image"`
 	Css         []string `json:"css"`
 	Js          []string `json:"js"`
+	Csp         CSP      `json:"csp"`
 }
 
+// CSP maps Content-Security-Policy directives to their sources.
+type CSP map[string][]string
+
 type Meta struct {
 	Title, Site  string
 	Tags, OGTags map[string]string
@@ -149,6 +288,68 @@ type Interest string
 
 type Tag string
 
+type Status string
+
+const (
+	StatusDraft     Status = "draft"
+	StatusPublished Status = "published"
+	StatusScheduled Status = "scheduled"
+)
+
+type ProjectOrder string
+
+const (
+	ProjectOrderManual ProjectOrder = "manual"
+	ProjectOrderNewest ProjectOrder = "newest"
+	ProjectOrderOldest ProjectOrder = "oldest"
+)
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+type Scope string
+
+const (
+	ScopeProjectsRead     Scope = "projects:read"
+	ScopeProjectsWrite    Scope = "projects:write"
+	ScopeContentRead      Scope = "content:read"
+	ScopeContentWrite     Scope = "content:write"
+	ScopeMenuRead         Scope = "menu:read"
+	ScopeMenuWrite        Scope = "menu:write"
+	ScopeSiteRead         Scope = "site:read"
+	ScopeSiteWrite        Scope = "site:write"
+	ScopeTrashRead        Scope = "trash:read"
+	ScopeTrashWrite       Scope = "trash:write"
+	ScopeMediaWrite       Scope = "media:write"
+	ScopeBackupRead       Scope = "backup:read"
+	ScopeBackupWrite      Scope = "backup:write"
+	ScopeMaintenanceRead  Scope = "maintenance:read"
+	ScopeMaintenanceWrite Scope = "maintenance:write"
+	ScopeAuditRead        Scope = "audit:read"
+)
+
+type RevisionKind string
+
+const (
+	RevisionProject RevisionKind = "project"
+	RevisionContent RevisionKind = "content"
+)
+
+type ProblemType string
+
+const (
+	ProblemDanglingMenuEntry ProblemType = "dangling-menu-entry"
+	ProblemOrphanedRoute     ProblemType = "orphaned-route"
+	ProblemMissingRoute      ProblemType = "missing-route"
+	ProblemMissingMedia      ProblemType = "missing-media"
+	ProblemUnreferencedMedia ProblemType = "unreferenced-media"
+)
+
 type PageType uint8
 
 const (
@@ -213,9 +414,11 @@ type UpdateMetaRequest struct {
 }
 
 type UpdateCredentialsRequest struct {
-	Email          string `json:"email"`
-	Password       string `json:"password"`
-	PasswordRepeat string `json:"password_repeat"`
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	PasswordRepeat  string `json:"password_repeat"`
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
 }
 
 type CreateProjectRequest struct {
@@ -235,7 +438,9 @@ type CreateProjectRequest struct {
 		Image []Media_ `json:"image"`
 	} `json:"client"`
 
-	Style string `json:"style"`
+	Style     string    `json:"style"`
+	Status    string    `json:"status"`
+	PublishAt time.Time `json:"publish_at"`
 }
 
 type UpdateProjectRequest struct {
@@ -257,7 +462,9 @@ type UpdateProjectRequest struct {
 		Image []Media_ `json:"image"`
 	} `json:"client"`
 
-	Style string `json:"style"`
+	Style     string    `json:"style"`
+	Status    string    `json:"status"`
+	PublishAt time.Time `json:"publish_at"`
 }
 
 type DeleteProjectRequest struct {
@@ -271,6 +478,8 @@ type CreateContentRequest struct {
 	Tags         []Tag        `json:"tags"`
 	References   Map          `json:"references"`
 	Technologies []Technology `json:"technologies"`
+	Status       string       `json:"status"`
+	PublishAt    time.Time    `json:"publish_at"`
 }
 
 type UpdateContentRequest struct {
@@ -282,12 +491,19 @@ type UpdateContentRequest struct {
 	Tags         []Tag        `json:"tags"`
 	References   Map          `json:"references"`
 	Technologies []Technology `json:"technologies"`
+	Status       string       `json:"status"`
+	PublishAt    time.Time    `json:"publish_at"`
 }
 
 type DeleteContentRequest struct {
 	Slug string `json:"slug"`
 }
 
+type ProjectOrderRequest struct {
+	Mode  string   `json:"mode"`
+	Slugs []string `json:"slugs"`
+}
+
 type AddToMenuRequest struct {
 	Slug string `json:"slug"`
 }
@@ -296,11 +512,50 @@ type RemoveFromMenuRequest struct {
 	Slug string `json:"slug"`
 }
 
+type InviteAccountRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+}
+
+type UpdateAccountRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type LoginVerifyRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+type TOTPRequest struct {
+	Code string `json:"code"`
+}
+
 //Response
 type GenericResponse struct {
 	Error   string      `json:"error,omitempty"`
@@ -330,6 +585,7 @@ type Project_ struct {
 	Title    string `json:"title"`
 	Subtitle string `json:"subtitle"`
 	Image    string `json:"image"`
+	Status   string `json:"status"`
 }
 
 type Content_ struct {
@@ -337,6 +593,7 @@ type Content_ struct {
 
 	Title    string `json:"title"`
 	Subtitle string `json:"subtitle"`
+	Status   string `json:"status"`
 }
 
 type Paragraph_ struct {
@@ -354,6 +611,119 @@ type Theme_ struct {
 	Path   string `json:"path"`
 }
 
+type ProjectOrder_ struct {
+	Mode  string   `json:"mode"`
+	Slugs []string `json:"slugs"`
+}
+
+type Revision_ struct {
+	ID      uint64    `json:"id"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+}
+
+type TrashItem_ struct {
+	ID      uint64    `json:"id"`
+	Kind    string    `json:"kind"`
+	Slug    string    `json:"slug"`
+	Title   string    `json:"title"`
+	Deleted time.Time `json:"deleted"`
+	Media   int       `json:"media"`
+}
+
+type Account_ struct {
+	ID      string    `json:"id"`
+	Email   string    `json:"email"`
+	Name    string    `json:"name"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+	TOTP    bool      `json:"totp"`
+}
+
+type Invitation_ struct {
+	Account  Account_ `json:"account"`
+	Password string   `json:"password"`
+}
+
+type Token_ struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+type APIKey_ struct {
+	ID       string    `json:"id"`
+	Account  string    `json:"account"`
+	Name     string    `json:"name"`
+	Scopes   []string  `json:"scopes"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+type NewAPIKey_ struct {
+	APIKey APIKey_ `json:"apiKey"`
+	Key    string  `json:"key"`
+}
+
+type AuditEntry_ struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	APIKey  string    `json:"apiKey,omitempty"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	IP      string    `json:"ip"`
+	Changes []string  `json:"changes"`
+	Status  int       `json:"status"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type AuditPage_ struct {
+	Entries []AuditEntry_ `json:"entries"`
+	Next    uint64        `json:"next,omitempty"`
+}
+
+type Challenge_ struct {
+	Challenge string `json:"challenge"`
+	ExpiresIn int64  `json:"expiresIn"`
+}
+
+type TOTPEnrollment_ struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type Session_ struct {
+	ID        string    `json:"id"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Current   bool      `json:"current"`
+}
+
+type Problem_ struct {
+	Type     string `json:"type"`
+	Subject  string `json:"subject"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+type MediaCollection_ struct {
+	Files      []string `json:"files"`
+	Bytes      int64    `json:"bytes"`
+	Quarantine string   `json:"quarantine,omitempty"`
+}
+
+type CacheStats_ struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
 type Menu_ struct {
 	Added  []string `json:"added"`
 	Routes []string `json:"routes"`
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// sortProjects orders projects in place. Manual order follows the stored
+// positions, ties and unknown modes fall back to the slug.
+func sortProjects(ps []Project, order ProjectOrder) {
+	sort.SliceStable(ps, func(i, j int) bool {
+		switch order {
+		case ProjectOrderNewest:
+			if !ps[i].Published.Equal(ps[j].Published) {
+				return ps[i].Published.After(ps[j].Published)
+			}
+		case ProjectOrderOldest:
+			if !ps[i].Published.Equal(ps[j].Published) {
+				return ps[i].Published.Before(ps[j].Published)
+			}
+		default:
+			if ps[i].Position != ps[j].Position {
+				return ps[i].Position < ps[j].Position
+			}
+		}
+
+		return ps[i].Slug < ps[j].Slug
+	})
+}
+
+// nextProjectPosition returns the position that appends a new project to
+// the manual order.
+func nextProjectPosition(ps []Project) int {
+	var n int
+	for _, p := range ps {
+		if p.Position >= n {
+			n = p.Position + 1
+		}
+	}
+
+	return n
+}
+
+func isProjectOrder(order ProjectOrder) bool {
+	switch order {
+	case ProjectOrderManual, ProjectOrderNewest, ProjectOrderOldest:
+		return true
+	}
+
+	return false
+}
+
+// checkOrder makes sure an ordering lists every slug exactly once.
+func checkOrder(slugs []string, n int) error {
+	if len(slugs) != n {
+		return ErrInvalidOrder
+	}
+
+	seen := make(map[string]bool, len(slugs))
+	for _, v := range slugs {
+		if seen[v] {
+			return ErrInvalidOrder
+		}
+		seen[v] = true
+	}
+
+	return nil
+}
+
+// Bolt
+func (db *cachedDatabase) ReorderProjects(slugs []string) error {
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_PROJECTS))
+
+		var n int
+		b.ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+
+		if err := checkOrder(slugs, n); err != nil {
+			return err
+		}
+
+		for i, slug := range slugs {
+			v := b.Get([]byte(slug))
+			if v == nil {
+				return ErrInvalidOrder
+			}
+
+			var p Project
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+
+			p.Position = i
+
+			if err := save(b, []byte(slug), p); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	db.cache.Delete("projects")
+	for _, slug := range slugs {
+		db.cache.Delete(fmt.Sprintf("project-%s", slug))
+	}
+
+	return nil
+}
+
+// SQLite
+func (db *sqliteDatabase) ReorderProjects(slugs []string) error {
+	err := db.update(func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM projects`).Scan(&n); err != nil {
+			return err
+		}
+
+		if err := checkOrder(slugs, n); err != nil {
+			return err
+		}
+
+		for i, slug := range slugs {
+			res, err := tx.Exec(`UPDATE projects SET data = json_set(data, '$.Position', ?) WHERE slug = ?`, i, slug)
+			if err != nil {
+				return err
+			}
+
+			if n, err := res.RowsAffected(); err != nil || n != 1 {
+				if err == nil {
+					err = ErrInvalidOrder
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	db.cache.Delete("projects")
+	for _, slug := range slugs {
+		db.cache.Delete(fmt.Sprintf("project-%s", slug))
+	}
+
+	return nil
+}
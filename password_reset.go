@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_RESETS string = "resets"
+
+	PasswordResetLifetime time.Duration = time.Hour
+
+	// PasswordResetInterval is the least time between two reset emails to
+	// the same account.
+	PasswordResetInterval time.Duration = time.Minute
+
+	passwordResetSubject string = "Reset your password"
+)
+
+// NewPasswordReset issues a reset for an account and returns it together
+// with the token to send, which is not kept.
+func NewPasswordReset(account string, d time.Duration) (PasswordReset, string) {
+	var (
+		now    = time.Now()
+		secret = GenerateSecret(32)
+	)
+
+	return PasswordReset{
+		Account: account,
+		Hash:    hashResetSecret(secret),
+		Created: now,
+		Expires: now.Add(d),
+	}, account + "." + secret
+}
+
+func hashResetSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// parseResetToken splits a token into the account and the hash of its
+// secret.
+func parseResetToken(token string) (string, string, bool) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], hashResetSecret(parts[1]), true
+}
+
+// checkPasswordReset reports whether a reset accepts the hash of a token,
+// and whether the reset is done with, either used or expired.
+func checkPasswordReset(r PasswordReset, hash string, now time.Time) (bool, bool) {
+	if !now.Before(r.Expires) {
+		return false, true
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.Hash), []byte(hash)) != 1 {
+		return false, false
+	}
+
+	return true, true
+}
+
+func passwordResetBody(token string, link string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Someone asked to reset the password of your account.\n\n")
+
+	if link != "" {
+		fmt.Fprintf(&b, "Open this link to choose a new one:\n%s?token=%s\n\n", link, token)
+	}
+
+	fmt.Fprintf(&b, "Reset token: %s\n\n", token)
+	fmt.Fprintf(&b, "The token can be used once and expires in %s. If you did not ask for it, ignore this email.\n", PasswordResetLifetime)
+
+	return b.String()
+}
+
+// setPassword replaces the password of an account and signs it out
+// everywhere.
+func setPassword(ctx context.Context, db DB, account string, password string) (Account, error) {
+	if password == "" {
+		return Account{}, ErrSetupEmpty
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return Account{}, err
+	}
+
+	a, err := db.UpdateAccount(account, func(a *Account) error {
+		a.Hash = string(hash)
+		return nil
+	})
+
+	if err != nil {
+		return a, err
+	}
+
+	if err := db.DeleteSessions(a.ID, ""); err != nil {
+		return a, err
+	}
+
+	if err := db.DeleteLoginAttempts(accountLoginKey(a.Email)); err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// resetPassword gives an account a generated password, for operators who
+// can reach the host but not the admin.
+func resetPassword(ctx context.Context, db DB, email string) (string, error) {
+	a, err := db.GetAccountByEmail(ctx, email)
+	if err != nil {
+		return "", err
+	}
+
+	password := GenerateSecret(12)
+
+	if _, err := setPassword(ctx, db, a.ID, password); err != nil {
+		return "", err
+	}
+
+	return password, db.DeletePasswordReset(a.ID)
+}
+
+// Bolt
+func (db *cachedDatabase) GetPasswordReset(ctx context.Context, account string) (PasswordReset, error) {
+	var r PasswordReset
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_RESETS))
+		if b == nil {
+			return ErrInvalidResetToken
+		}
+
+		v := b.Get([]byte(account))
+		if v == nil {
+			return ErrInvalidResetToken
+		}
+
+		return json.Unmarshal(v, &r)
+	})
+
+	if err != nil && err != ErrInvalidResetToken {
+		db.logger.Error("cannot get password reset", zap.Error(err))
+		return r, ErrDatabase
+	}
+
+	return r, err
+}
+
+// PutPasswordReset stores a reset, replacing an earlier one of the account.
+func (db *cachedDatabase) PutPasswordReset(reset *PasswordReset) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_RESETS))
+		if err != nil {
+			return err
+		}
+
+		return save(b, []byte(reset.Account), reset)
+	})
+}
+
+// UsePasswordReset consumes the reset of an account if it accepts the hash
+// of a token, see checkPasswordReset.
+func (db *cachedDatabase) UsePasswordReset(account string, hash string, now time.Time) error {
+	var valid bool
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_RESETS))
+		if b == nil {
+			return nil
+		}
+
+		v := b.Get([]byte(account))
+		if v == nil {
+			return nil
+		}
+
+		var r PasswordReset
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+
+		var done bool
+		if valid, done = checkPasswordReset(r, hash, now); done {
+			return b.Delete([]byte(account))
+		}
+
+		return nil
+	})
+
+	if err == nil && !valid {
+		err = ErrInvalidResetToken
+	}
+
+	return err
+}
+
+func (db *cachedDatabase) DeletePasswordReset(account string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_RESETS))
+		if b == nil {
+			return nil
+		}
+
+		return b.Delete([]byte(account))
+	})
+}
+
+// SQLite
+func (db *sqliteDatabase) GetPasswordReset(ctx context.Context, account string) (PasswordReset, error) {
+	var r PasswordReset
+	err := db.getRecord(ctx, `SELECT data FROM resets WHERE account = ?`, account, &r)
+	if err == sql.ErrNoRows {
+		return r, ErrInvalidResetToken
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get password reset", zap.Error(err))
+		return r, ErrDatabase
+	}
+
+	return r, nil
+}
+
+func (db *sqliteDatabase) PutPasswordReset(reset *PasswordReset) error {
+	data, err := json.Marshal(reset)
+	if err != nil {
+		return err
+	}
+
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO resets (account, data) VALUES (?, ?)`, reset.Account, string(data))
+		return err
+	})
+}
+
+func (db *sqliteDatabase) UsePasswordReset(account string, hash string, now time.Time) error {
+	var valid bool
+	err := db.update(func(tx *sql.Tx) error {
+		var (
+			r    PasswordReset
+			data string
+		)
+
+		err := tx.QueryRow(`SELECT data FROM resets WHERE account = ?`, account).Scan(&data)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			return err
+		}
+
+		var done bool
+		if valid, done = checkPasswordReset(r, hash, now); done {
+			_, err = tx.Exec(`DELETE FROM resets WHERE account = ?`, account)
+		}
+
+		return err
+	})
+
+	if err == nil && !valid {
+		err = ErrInvalidResetToken
+	}
+
+	return err
+}
+
+func (db *sqliteDatabase) DeletePasswordReset(account string) error {
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM resets WHERE account = ?`, account)
+		return err
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	PublishInterval time.Duration = time.Minute
+)
+
+func (p *Project) Public(now time.Time) bool {
+	return isPublic(p.Status, p.PublishAt, now)
+}
+
+func (c *Content) Public(now time.Time) bool {
+	return isPublic(c.Status, c.PublishAt, now)
+}
+
+// isPublic reports whether an item may be shown to visitors. Items stored
+// before publication states existed have no status and stay public.
+func isPublic(status Status, publishAt time.Time, now time.Time) bool {
+	switch status {
+	case StatusDraft:
+		return false
+	case StatusScheduled:
+		return !now.Before(publishAt)
+	}
+
+	return true
+}
+
+// setStatus validates a requested status and applies it to an item. An
+// empty status keeps the current one, or publishes new items. Publishing
+// a draft stamps its publication time.
+func setStatus(status *Status, publishAt *time.Time, published *time.Time, s string, at time.Time) error {
+	switch Status(s) {
+	case "":
+		if *status == "" {
+			*status = StatusPublished
+		}
+
+		return nil
+	case StatusDraft:
+		*publishAt = time.Time{}
+	case StatusPublished:
+		if *status != "" && *status != StatusPublished {
+			*published = time.Now()
+		}
+
+		*publishAt = time.Time{}
+	case StatusScheduled:
+		if at.IsZero() {
+			return ErrInvalidStatus
+		}
+
+		*publishAt = at
+	default:
+		return ErrInvalidStatus
+	}
+
+	*status = Status(s)
+
+	return nil
+}
+
+func publicProjects(ps []Project, now time.Time) []Project {
+	var r = make([]Project, 0, len(ps))
+	for i := range ps {
+		if ps[i].Public(now) {
+			r = append(r, ps[i])
+		}
+	}
+
+	return r
+}
+
+func hiddenContents(cs []Content, now time.Time) map[string]bool {
+	var r = make(map[string]bool)
+	for i := range cs {
+		if !cs[i].Public(now) {
+			r[cs[i].Slug] = true
+		}
+	}
+
+	return r
+}
+
+// Publisher flips scheduled projects and pages to published once their
+// publication time has come.
+type Publisher struct {
+	db       DB
+	logger   *zap.Logger
+	interval time.Duration
+	stop     chan bool
+}
+
+func NewPublisher(db DB, logger *zap.Logger, interval time.Duration) *Publisher {
+	return &Publisher{
+		db:       db,
+		logger:   logger,
+		interval: interval,
+		stop:     make(chan bool),
+	}
+}
+
+func (p *Publisher) Run() {
+	p.Publish()
+
+	ticker := time.NewTicker(p.interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				p.Publish()
+			case <-p.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (p *Publisher) Publish() {
+	var (
+		ctx = context.TODO()
+		now = time.Now()
+	)
+
+	ps, err := p.db.GetProjects(ctx)
+	if err != nil {
+		p.logger.Error("cannot get projects to publish", zap.Error(err))
+	}
+
+	for _, v := range ps {
+		if v.Status != StatusScheduled || now.Before(v.PublishAt) {
+			continue
+		}
+
+		v.Status = StatusPublished
+		v.Published = v.PublishAt
+		v.PublishAt = time.Time{}
+
+		if err := p.db.PutProject(&v); err != nil {
+			p.logger.Error("cannot publish project", zap.String("slug", v.Slug), zap.Error(err))
+			continue
+		}
+
+		p.logger.Info("published", zap.String("project", v.Slug))
+	}
+
+	cs, err := p.db.GetContents(ctx)
+	if err != nil {
+		p.logger.Error("cannot get contents to publish", zap.Error(err))
+	}
+
+	for _, v := range cs {
+		if v.Status != StatusScheduled || now.Before(v.PublishAt) {
+			continue
+		}
+
+		v.Status = StatusPublished
+		v.Published = v.PublishAt
+		v.PublishAt = time.Time{}
+
+		if err := p.db.PutContent(&v); err != nil {
+			p.logger.Error("cannot publish content", zap.String("slug", v.Slug), zap.Error(err))
+			continue
+		}
+
+		p.logger.Info("published", zap.String("content", v.Slug))
+	}
+}
+
+func (p *Publisher) Finalize() {
+	p.stop <- true
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_REDIRECTS string = "redirects"
+)
+
+// redirectPath is the public location of a project or page.
+func redirectPath(kind RevisionKind, slug string) string {
+	if kind == RevisionProject {
+		return "/project/" + slug
+	}
+
+	return "/page/" + slug
+}
+
+func renameMenuEntry(m Menu, from string, to string) bool {
+	for k, v := range m {
+		if v.Slug == from {
+			v.Slug = to
+			m[k] = v
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bolt
+func (db *cachedDatabase) GetRedirect(ctx context.Context, kind RevisionKind, slug string) (Redirect, error) {
+	var r Redirect
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_REDIRECTS))
+		if b == nil {
+			return ErrRedirectNotFound
+		}
+
+		v := b.Get(itemKey(kind, slug))
+		if v == nil {
+			return ErrRedirectNotFound
+		}
+
+		return json.Unmarshal(v, &r)
+	})
+
+	if err != nil && err != ErrRedirectNotFound {
+		db.logger.Error("cannot get redirect", zap.Error(err))
+		return r, ErrDatabase
+	}
+
+	return r, err
+}
+
+func (db *cachedDatabase) GetRedirects(ctx context.Context) ([]Redirect, error) {
+	rs := make([]Redirect, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_REDIRECTS))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var r Redirect
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			rs = append(rs, r)
+			return nil
+		})
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get redirects", zap.Error(err))
+		return rs, ErrDatabase
+	}
+
+	return rs, nil
+}
+
+// PutRedirect stores a redirect as it is, e.g. when a site is copied.
+// Renames add their redirects themselves.
+func (db *cachedDatabase) PutRedirect(redirect *Redirect) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_REDIRECTS))
+		if err != nil {
+			return err
+		}
+
+		return save(b, itemKey(redirect.Kind, redirect.From), redirect)
+	})
+}
+
+func (db *cachedDatabase) RenameProject(from string, to string) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.RenameProject(from, to)
+	})
+}
+
+func (db *cachedDatabase) RenameContent(from string, to string) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.RenameContent(from, to)
+	})
+}
+
+func (t *boltTx) RenameProject(from string, to string) error {
+	return t.rename(RevisionProject, BUCKET_PROJECTS, from, to)
+}
+
+func (t *boltTx) RenameContent(from string, to string) error {
+	return t.rename(RevisionContent, BUCKET_CONTENT, from, to)
+}
+
+// rename moves a record to a new slug together with its route, menu entry
+// and history, and leaves a redirect behind. Redirects that pointed to the
+// old slug are updated, so a visitor is never sent through a chain.
+func (t *boltTx) rename(kind RevisionKind, bucket string, from string, to string) error {
+	if to == "" {
+		return ErrNoSlug
+	}
+
+	var (
+		tx = t.tx
+		b  = tx.Bucket([]byte(bucket))
+		rb = tx.Bucket([]byte(BUCKET_ROUTES))
+	)
+
+	v := b.Get([]byte(from))
+	if v == nil {
+		return notFound(kind)
+	}
+
+	if b.Get([]byte(to)) != nil || (kind == RevisionContent && rb.Get([]byte(to)) != nil) {
+		return exists(kind)
+	}
+
+	var r map[string]interface{}
+	if err := json.Unmarshal(v, &r); err != nil {
+		return err
+	}
+
+	r["Slug"] = to
+
+	if err := save(b, []byte(to), r); err != nil {
+		return err
+	}
+
+	if err := b.Delete([]byte(from)); err != nil {
+		return err
+	}
+
+	if v := rb.Get([]byte(from)); v != nil {
+		var route Route
+		if err := json.Unmarshal(v, &route); err != nil {
+			return err
+		}
+
+		route.Slug = to
+
+		if err := save(rb, []byte(to), route); err != nil {
+			return err
+		}
+
+		if err := rb.Delete([]byte(from)); err != nil {
+			return err
+		}
+	}
+
+	m, err := readMenu(tx)
+	if err != nil {
+		return err
+	}
+
+	if renameMenuEntry(m, from, to) {
+		if err := save(tx.Bucket([]byte(BUCKET_COMMON)), []byte("menu"), m); err != nil {
+			return err
+		}
+	}
+
+	if err := renameHistory(tx, kind, from, to); err != nil {
+		return err
+	}
+
+	err = putRedirect(tx, Redirect{
+		Kind:    kind,
+		From:    from,
+		To:      to,
+		Created: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete(itemCacheKeys(kind, from)...)
+	t.ops.delete(itemCacheKeys(kind, to)...)
+
+	return nil
+}
+
+func renameHistory(tx *bolt.Tx, kind RevisionKind, from string, to string) error {
+	hb := tx.Bucket([]byte(BUCKET_HISTORY))
+	if hb == nil {
+		return nil
+	}
+
+	old := hb.Bucket(itemKey(kind, from))
+	if old == nil {
+		return nil
+	}
+
+	if hb.Bucket(itemKey(kind, to)) != nil {
+		if err := hb.DeleteBucket(itemKey(kind, to)); err != nil {
+			return err
+		}
+	}
+
+	h, err := hb.CreateBucket(itemKey(kind, to))
+	if err != nil {
+		return err
+	}
+
+	err = old.ForEach(func(k, v []byte) error {
+		var rev Revision
+		if err := json.Unmarshal(v, &rev); err != nil {
+			return err
+		}
+
+		rev.Slug = to
+
+		return save(h, k, rev)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = h.SetSequence(old.Sequence()); err != nil {
+		return err
+	}
+
+	return hb.DeleteBucket(itemKey(kind, from))
+}
+
+func putRedirect(tx *bolt.Tx, redirect Redirect) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_REDIRECTS))
+	if err != nil {
+		return err
+	}
+
+	var chained []Redirect
+	err = b.ForEach(func(k, v []byte) error {
+		var r Redirect
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+
+		if r.Kind == redirect.Kind && r.To == redirect.From {
+			chained = append(chained, r)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range chained {
+		r.To = redirect.To
+
+		if err := save(b, itemKey(r.Kind, r.From), r); err != nil {
+			return err
+		}
+	}
+
+	// The new slug is live again, it must not redirect anywhere.
+	if err := b.Delete(itemKey(redirect.Kind, redirect.To)); err != nil {
+		return err
+	}
+
+	return save(b, itemKey(redirect.Kind, redirect.From), redirect)
+}
+
+// SQLite
+func (db *sqliteDatabase) GetRedirect(ctx context.Context, kind RevisionKind, slug string) (Redirect, error) {
+	var (
+		r       Redirect
+		created string
+	)
+
+	err := db.sql.QueryRowContext(ctx, `SELECT to_slug, created FROM redirects WHERE kind = ? AND from_slug = ?`, string(kind), slug).Scan(&r.To, &created)
+	if err == sql.ErrNoRows {
+		return r, ErrRedirectNotFound
+	}
+
+	if err == nil {
+		r.Kind, r.From = kind, slug
+		r.Created, err = time.Parse(time.RFC3339Nano, created)
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get redirect", zap.Error(err))
+		return r, ErrDatabase
+	}
+
+	return r, nil
+}
+
+func (db *sqliteDatabase) GetRedirects(ctx context.Context) ([]Redirect, error) {
+	rs := make([]Redirect, 0)
+
+	rows, err := db.sql.QueryContext(ctx, `SELECT kind, from_slug, to_slug, created FROM redirects ORDER BY kind, from_slug`)
+	if err == nil {
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				r       Redirect
+				kind    string
+				created string
+			)
+
+			if err = rows.Scan(&kind, &r.From, &r.To, &created); err != nil {
+				break
+			}
+
+			r.Kind = RevisionKind(kind)
+			if r.Created, err = time.Parse(time.RFC3339Nano, created); err != nil {
+				break
+			}
+
+			rs = append(rs, r)
+		}
+
+		if err == nil {
+			err = rows.Err()
+		}
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get redirects", zap.Error(err))
+		return rs, ErrDatabase
+	}
+
+	return rs, nil
+}
+
+func (db *sqliteDatabase) PutRedirect(redirect *Redirect) error {
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO redirects (kind, from_slug, to_slug, created) VALUES (?, ?, ?, ?)`,
+			string(redirect.Kind), redirect.From, redirect.To, redirect.Created.UTC().Format(time.RFC3339Nano))
+		return err
+	})
+}
+
+func (db *sqliteDatabase) RenameProject(from string, to string) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.RenameProject(from, to)
+	})
+}
+
+func (db *sqliteDatabase) RenameContent(from string, to string) error {
+	return db.Atomic(func(tx Tx) error {
+		return tx.RenameContent(from, to)
+	})
+}
+
+func (t *sqliteTx) RenameProject(from string, to string) error {
+	return t.rename(RevisionProject, "projects", from, to)
+}
+
+func (t *sqliteTx) RenameContent(from string, to string) error {
+	return t.rename(RevisionContent, "contents", from, to)
+}
+
+func (t *sqliteTx) rename(kind RevisionKind, table string, from string, to string) error {
+	if to == "" {
+		return ErrNoSlug
+	}
+
+	tx := t.tx
+
+	var n int
+	if err := tx.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE slug = ?`, table), from).Scan(&n); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return notFound(kind)
+	}
+
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE slug = ?`, table)
+	if kind == RevisionContent {
+		query = `SELECT (SELECT COUNT(*) FROM contents WHERE slug = ?1) + (SELECT COUNT(*) FROM routes WHERE slug = ?1)`
+	}
+
+	if err := tx.QueryRow(query, to).Scan(&n); err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return exists(kind)
+	}
+
+	statements := []string{
+		fmt.Sprintf(`UPDATE %s SET slug = ?2, data = json_set(data, '$.Slug', ?2) WHERE slug = ?1`, table),
+		`UPDATE routes SET slug = ?2 WHERE slug = ?1`,
+		`UPDATE menu SET slug = ?2 WHERE slug = ?1`,
+	}
+
+	for _, q := range statements {
+		if _, err := tx.Exec(q, from, to); err != nil {
+			return err
+		}
+	}
+
+	var (
+		k   = string(kind)
+		now = time.Now().UTC().Format(time.RFC3339Nano)
+	)
+
+	updates := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM revisions WHERE kind = ? AND slug = ?`, []interface{}{k, to}},
+		{`UPDATE revisions SET slug = ? WHERE kind = ? AND slug = ?`, []interface{}{to, k, from}},
+		{`UPDATE redirects SET to_slug = ? WHERE kind = ? AND to_slug = ?`, []interface{}{to, k, from}},
+		{`DELETE FROM redirects WHERE kind = ? AND from_slug = ?`, []interface{}{k, to}},
+		{`INSERT OR REPLACE INTO redirects (kind, from_slug, to_slug, created) VALUES (?, ?, ?, ?)`, []interface{}{k, from, to, now}},
+	}
+
+	for _, u := range updates {
+		if _, err := tx.Exec(u.query, u.args...); err != nil {
+			return err
+		}
+	}
+
+	t.ops.delete(itemCacheKeys(kind, from)...)
+	t.ops.delete(itemCacheKeys(kind, to)...)
+
+	return nil
+}
@@ -38,6 +38,7 @@ var (
 type Renderer struct {
 	configuration Configuration
 	templates     map[string]*template.Template
+	csp           CSP
 	shortUrl      string
 }
 
@@ -68,20 +69,37 @@ func (r *Renderer) Configure(configuration Configuration) error {
 }
 
 func (r *Renderer) LoadTheme() error {
-	templates, err := loadTheme(r.configuration.CurrentThemePath, r)
+	var path = r.configuration.CurrentThemePath
+
+	templates, err := loadTheme(path, r)
 
 	if err != nil {
-		templates, err = loadTheme(DefaultTheme, r)
+		path = DefaultTheme
+
+		templates, err = loadTheme(path, r)
 		if err != nil {
 			return err
 		}
 	}
 
+	// The policy follows theme.json as it is now, the copy stored when the
+	// theme was chosen may be older.
+	t, err := NewThemeScanner().LoadTheme(path)
+	if err != nil {
+		t = r.configuration.CurrentTheme
+	}
+
 	r.templates = templates
+	r.csp = NewThemeCSP(t)
 
 	return nil
 }
 
+// CSP returns the Content-Security-Policy of the loaded theme.
+func (r *Renderer) CSP() CSP {
+	return r.csp
+}
+
 func loadTheme(path string, r *Renderer) (map[string]*template.Template, error) {
 	if !filesExist(path) {
 		return nil, ErrInvalidTheme
@@ -124,7 +142,8 @@ func filesExist(path string) bool {
 	return true
 }
 
-func (r *Renderer) Render(b *bytes.Buffer, p *Page, ro string) error {
+// Render writes a page. Themes use the nonce to mark their inline scripts.
+func (r *Renderer) Render(b *bytes.Buffer, p *Page, ro string, nonce string) error {
 	if r.templates == nil {
 		return ErrNoTheme
 	}
@@ -140,6 +159,7 @@ func (r *Renderer) Render(b *bytes.Buffer, p *Page, ro string) error {
 			"User":  p.User,
 			"Css":   r.configuration.CurrentTheme.Css,
 			"Js":    r.configuration.CurrentTheme.Js,
+			"Nonce": nonce,
 			"active": func(s string) bool {
 				var l string
 				{
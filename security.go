@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CSPEnforce    string = "enforce"
+	CSPReportOnly string = "report-only"
+	CSPOff        string = "off"
+
+	ReferrerPolicy    string        = "strict-origin-when-cross-origin"
+	PermissionsPolicy string        = "camera=(), microphone=(), geolocation=(), payment=()"
+	HSTSMaxAge        time.Duration = 180 * 24 * time.Hour
+)
+
+// SecurityOptions are the headers public pages are served with.
+type SecurityOptions struct {
+	// CSP is one of enforce, report-only or off.
+	CSP          string
+	CSPReportURI string
+
+	ReferrerPolicy    string
+	PermissionsPolicy string
+
+	// HSTSMaxAge is sent only over TLS, 0 sends no HSTS header.
+	HSTSMaxAge time.Duration
+}
+
+func NewSecurityOptions(csp string, reportURI string, referrer string, permissions string, hsts time.Duration) (SecurityOptions, error) {
+	switch csp {
+	case CSPEnforce, CSPReportOnly, CSPOff:
+	default:
+		return SecurityOptions{}, ErrInvalidCSPMode
+	}
+
+	return SecurityOptions{
+		CSP:               csp,
+		CSPReportURI:      reportURI,
+		ReferrerPolicy:    referrer,
+		PermissionsPolicy: permissions,
+		HSTSMaxAge:        hsts,
+	}, nil
+}
+
+// cspHeader is the header a policy is sent in, none when it is off.
+func (o SecurityOptions) cspHeader() string {
+	switch o.CSP {
+	case CSPEnforce:
+		return "Content-Security-Policy"
+	case CSPReportOnly:
+		return "Content-Security-Policy-Report-Only"
+	}
+
+	return ""
+}
+
+// cspOrder lists directives in the order they are sent, others follow
+// sorted by name.
+var cspOrder = []string{
+	"default-src",
+	"script-src",
+	"style-src",
+	"img-src",
+	"font-src",
+	"connect-src",
+	"media-src",
+	"object-src",
+	"frame-src",
+	"frame-ancestors",
+	"base-uri",
+	"form-action",
+}
+
+// NewThemeCSP builds the policy of a theme. Its own assets and those its
+// css and js lists load from other origins are allowed, along with the
+// sources of its csp section.
+func NewThemeCSP(t Theme) CSP {
+	p := CSP{
+		"default-src": {"'self'"},
+		"script-src":  {"'self'"},
+		// Themes and page content set style attributes.
+		"style-src":       {"'self'", "'unsafe-inline'"},
+		"img-src":         {"'self'", "data:"},
+		"media-src":       {"'self'"},
+		"object-src":      {"'none'"},
+		"frame-ancestors": {"'self'"},
+		"base-uri":        {"'self'"},
+		"form-action":     {"'self'"},
+	}
+
+	for _, v := range t.Css {
+		if o := assetOrigin(v); o != "" {
+			p.add("style-src", o)
+		}
+	}
+
+	for _, v := range t.Js {
+		if o := assetOrigin(v); o != "" {
+			p.add("script-src", o)
+		}
+	}
+
+	for d, sources := range t.Csp {
+		for _, s := range sources {
+			p.add(strings.ToLower(d), s)
+		}
+	}
+
+	return p
+}
+
+// assetOrigin returns the origin of an asset on another host, or nothing
+// for one of the theme.
+func assetOrigin(asset string) string {
+	u, err := url.Parse(asset)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	if u.Scheme == "" {
+		return u.Host
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+func (p CSP) add(directive string, source string) {
+	// A fetch directive no longer falls back to default-src once given, so
+	// it starts out with its sources.
+	if _, ok := p[directive]; !ok && strings.HasSuffix(directive, "-src") {
+		p[directive] = append([]string(nil), p["default-src"]...)
+	}
+
+	for _, v := range p[directive] {
+		if v == source {
+			return
+		}
+	}
+
+	// 'none' only stands alone.
+	if l := p[directive]; len(l) == 1 && l[0] == "'none'" {
+		p[directive] = nil
+	}
+
+	p[directive] = append(p[directive], source)
+}
+
+// String renders the policy, allowing inline scripts that carry the nonce.
+func (p CSP) String(nonce string, reportURI string) string {
+	var (
+		seen  = make(map[string]bool)
+		names = make([]string, 0, len(p))
+		rest  []string
+	)
+
+	for _, d := range cspOrder {
+		if _, ok := p[d]; ok {
+			names = append(names, d)
+			seen[d] = true
+		}
+	}
+
+	for d := range p {
+		if !seen[d] {
+			rest = append(rest, d)
+		}
+	}
+
+	sort.Strings(rest)
+	names = append(names, rest...)
+
+	var parts []string
+	for _, d := range names {
+		sources := p[d]
+		if d == "script-src" && nonce != "" {
+			sources = append(sources[:len(sources):len(sources)], "'nonce-"+nonce+"'")
+		}
+
+		parts = append(parts, strings.TrimSpace(d+" "+strings.Join(sources, " ")))
+	}
+
+	if reportURI != "" {
+		parts = append(parts, "report-uri "+reportURI)
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func NewCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func withCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, cspNonceContextKey, nonce)
+}
+
+// cspNonce returns the nonce of the policy a page is served with, for its
+// inline scripts.
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// servedOverTLS reports whether a request reached the site over TLS,
+// directly or through a proxy. A forged header only makes a browser see
+// HSTS over plain HTTP, which it ignores.
+func servedOverTLS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func hstsValue(d time.Duration) string {
+	return "max-age=" + strconv.FormatInt(int64(d/time.Second), 10)
+}
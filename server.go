@@ -9,12 +9,12 @@ import (
 	"net/url"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/kataras/go-fs"
 
@@ -78,7 +78,7 @@ func (n *NotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	b := n.s.bp.Get()
 	defer n.s.bp.Put(b)
 
-	err := n.s.r.Render(b, &p, r.URL.Path)
+	err := n.s.r.Render(b, &p, r.URL.Path, cspNonce(r.Context()))
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -96,6 +96,18 @@ func (n *NotFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type RouteHandler struct {
 	Method  string
 	Handler func(*Server) func(http.ResponseWriter, *http.Request)
+
+	// Role is the least role an account needs to use a protected route.
+	Role Role
+
+	// Scope is the scope an API key needs to use a protected route, routes
+	// without one are only open to signed in accounts.
+	Scope Scope
+
+	// AuditReads records a protected GET route in the audit log even when
+	// reads are not recorded (-audit.reads=false). It is set on reads that
+	// hand out the whole site.
+	AuditReads bool
 }
 
 var (
@@ -142,107 +154,359 @@ var (
 			Method:  "POST",
 			Handler: loginHandler,
 		},
+		"/admin/login/verify": RouteHandler{
+			Method:  "POST",
+			Handler: loginVerifyHandler,
+		},
+		"/admin/refresh": RouteHandler{
+			Method:  "POST",
+			Handler: refreshHandler,
+		},
+		"/admin/password/forgot": RouteHandler{
+			Method:  "POST",
+			Handler: forgotPasswordHandler,
+		},
+		"/admin/password/reset": RouteHandler{
+			Method:  "POST",
+			Handler: resetPasswordHandler,
+		},
 	}
 
 	adminRoutes_Protected = map[string]RouteHandler{
 		"/admin/projects": RouteHandler{
 			Method:  "GET",
 			Handler: getProjectsHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeProjectsRead,
+		},
+		"/admin/projects/order": RouteHandler{
+			Method:  "GET",
+			Handler: getProjectOrderHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeProjectsRead,
+		},
+		"/admin/projects/order/update": RouteHandler{
+			Method:  "PUT",
+			Handler: updateProjectOrderHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeProjectsWrite,
 		},
 		"/admin/contents": RouteHandler{
 			Method:  "GET",
 			Handler: getContentsHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeContentRead,
 		},
 
 		"/admin/user": RouteHandler{
 			Method:  "GET",
 			Handler: getUserHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeSiteRead,
 		},
 		"/admin/theme": RouteHandler{
 			Method:  "GET",
 			Handler: getThemeHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeSiteRead,
 		},
 		"/admin/meta": RouteHandler{
 			Method:  "GET",
 			Handler: getMetaHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeSiteRead,
 		},
 		"/admin/credentials": RouteHandler{
 			Method:  "GET",
 			Handler: getCredentialsHandler,
+			Role:    RoleViewer,
 		},
 
 		"/admin/user/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateUserHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeSiteWrite,
 		},
 		"/admin/theme/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateThemeHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeSiteWrite,
 		},
 		"/admin/meta/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateMetaHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeSiteWrite,
 		},
 		"/admin/credentials/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateCredentialsHandler,
+			Role:    RoleViewer,
+		},
+
+		"/admin/accounts": RouteHandler{
+			Method:  "GET",
+			Handler: getAccountsHandler,
+			Role:    RoleOwner,
+		},
+		"/admin/accounts/invite": RouteHandler{
+			Method:  "POST",
+			Handler: inviteAccountHandler,
+			Role:    RoleOwner,
+		},
+		"/admin/accounts/{id}/update": RouteHandler{
+			Method:  "PUT",
+			Handler: updateAccountHandler,
+			Role:    RoleOwner,
+		},
+		"/admin/accounts/{id}/delete": RouteHandler{
+			Method:  "DELETE",
+			Handler: deleteAccountHandler,
+			Role:    RoleOwner,
+		},
+
+		"/admin/apikeys": RouteHandler{
+			Method:  "GET",
+			Handler: getAPIKeysHandler,
+			Role:    RoleOwner,
+		},
+		"/admin/apikeys/create": RouteHandler{
+			Method:  "POST",
+			Handler: createAPIKeyHandler,
+			Role:    RoleOwner,
+		},
+		"/admin/apikeys/{id}/revoke": RouteHandler{
+			Method:  "DELETE",
+			Handler: revokeAPIKeyHandler,
+			Role:    RoleOwner,
+		},
+
+		"/admin/totp/enroll": RouteHandler{
+			Method:  "POST",
+			Handler: enrollTOTPHandler,
+			Role:    RoleViewer,
+		},
+		"/admin/totp/enable": RouteHandler{
+			Method:  "PUT",
+			Handler: enableTOTPHandler,
+			Role:    RoleViewer,
+		},
+		"/admin/totp/disable": RouteHandler{
+			Method:  "PUT",
+			Handler: disableTOTPHandler,
+			Role:    RoleViewer,
+		},
+
+		"/admin/sessions": RouteHandler{
+			Method:  "GET",
+			Handler: getSessionsHandler,
+			Role:    RoleViewer,
+		},
+		"/admin/sessions/{id}/revoke": RouteHandler{
+			Method:  "DELETE",
+			Handler: revokeSessionHandler,
+			Role:    RoleViewer,
+		},
+		"/admin/sessions/revoke-others": RouteHandler{
+			Method:  "DELETE",
+			Handler: revokeOtherSessionsHandler,
+			Role:    RoleViewer,
+		},
+
+		"/admin/audit": RouteHandler{
+			Method:  "GET",
+			Handler: getAuditHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeAuditRead,
+		},
+
+		"/admin/cache": RouteHandler{
+			Method:  "GET",
+			Handler: getCacheStatsHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeMaintenanceRead,
+		},
+		"/admin/media/collect": RouteHandler{
+			Method:  "PUT",
+			Handler: collectMediaHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeMediaWrite,
+		},
+
+		"/admin/fsck": RouteHandler{
+			Method:  "GET",
+			Handler: checkHandler(false),
+			Role:    RoleOwner,
+			Scope:   ScopeMaintenanceRead,
+		},
+		"/admin/fsck/repair": RouteHandler{
+			Method:  "PUT",
+			Handler: checkHandler(true),
+			Role:    RoleOwner,
+			Scope:   ScopeMaintenanceWrite,
 		},
 
 		"/admin/project/{slug}": RouteHandler{
 			Method:  "GET",
 			Handler: getProjectHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeProjectsRead,
 		},
 		"/admin/project/create": RouteHandler{
 			Method:  "POST",
 			Handler: createProjectHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeProjectsWrite,
 		},
 		"/admin/project/{slug}/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateProjectHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeProjectsWrite,
 		},
 		"/admin/project/{slug}/delete": RouteHandler{
 			Method:  "DELETE",
 			Handler: deleteProjectHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeProjectsWrite,
+		},
+
+		"/admin/project/{slug}/revisions": RouteHandler{
+			Method:  "GET",
+			Handler: getRevisionsHandler(RevisionProject),
+			Role:    RoleViewer,
+			Scope:   ScopeProjectsRead,
+		},
+		"/admin/project/{slug}/revisions/diff": RouteHandler{
+			Method:  "GET",
+			Handler: diffRevisionsHandler(RevisionProject),
+			Role:    RoleViewer,
+			Scope:   ScopeProjectsRead,
+		},
+		"/admin/project/{slug}/revisions/{id:[0-9]+}/restore": RouteHandler{
+			Method:  "PUT",
+			Handler: restoreRevisionHandler(RevisionProject),
+			Role:    RoleEditor,
+			Scope:   ScopeProjectsWrite,
 		},
 
 		"/admin/content/{slug}": RouteHandler{
 			Method:  "GET",
 			Handler: getContentHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeContentRead,
 		},
 		"/admin/content/create": RouteHandler{
 			Method:  "POST",
 			Handler: createContentHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeContentWrite,
 		},
 		"/admin/content/{slug}/update": RouteHandler{
 			Method:  "PUT",
 			Handler: updateContentHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeContentWrite,
 		},
 		"/admin/content/{slug}/delete": RouteHandler{
 			Method:  "DELETE",
 			Handler: deleteContentHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeContentWrite,
+		},
+
+		"/admin/content/{slug}/revisions": RouteHandler{
+			Method:  "GET",
+			Handler: getRevisionsHandler(RevisionContent),
+			Role:    RoleViewer,
+			Scope:   ScopeContentRead,
+		},
+		"/admin/content/{slug}/revisions/diff": RouteHandler{
+			Method:  "GET",
+			Handler: diffRevisionsHandler(RevisionContent),
+			Role:    RoleViewer,
+			Scope:   ScopeContentRead,
+		},
+		"/admin/content/{slug}/revisions/{id:[0-9]+}/restore": RouteHandler{
+			Method:  "PUT",
+			Handler: restoreRevisionHandler(RevisionContent),
+			Role:    RoleEditor,
+			Scope:   ScopeContentWrite,
+		},
+
+		"/admin/trash": RouteHandler{
+			Method:  "GET",
+			Handler: getTrashHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeTrashRead,
+		},
+		"/admin/trash/{id:[0-9]+}/restore": RouteHandler{
+			Method:  "PUT",
+			Handler: restoreTrashHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeTrashWrite,
+		},
+		"/admin/trash/{id:[0-9]+}/purge": RouteHandler{
+			Method:  "DELETE",
+			Handler: purgeTrashHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeTrashWrite,
 		},
 
 		"/admin/menu": RouteHandler{
 			Method:  "GET",
 			Handler: getMenuHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeMenuRead,
 		},
 		"/admin/menu/add": RouteHandler{
 			Method:  "PUT",
 			Handler: addToMenuHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeMenuWrite,
 		},
 		"/admin/menu/remove": RouteHandler{
 			Method:  "PUT",
 			Handler: deleteFromMenuHandler,
+			Role:    RoleEditor,
+			Scope:   ScopeMenuWrite,
 		},
 
 		"/admin/site": RouteHandler{
 			Method:  "GET",
 			Handler: siteHandler,
+			Role:    RoleViewer,
+			Scope:   ScopeSiteRead,
+		},
+
+		"/admin/export": RouteHandler{
+			Method:     "GET",
+			Handler:    exportHandler,
+			Role:       RoleOwner,
+			Scope:      ScopeBackupRead,
+			AuditReads: true,
+		},
+		"/admin/import": RouteHandler{
+			Method:  "POST",
+			Handler: importHandler,
+			Role:    RoleOwner,
+			Scope:   ScopeBackupWrite,
+		},
+
+		"/admin/backup": RouteHandler{
+			Method:     "GET",
+			Handler:    backupHandler,
+			Role:       RoleOwner,
+			Scope:      ScopeBackupRead,
+			AuditReads: true,
 		},
 
 		"/admin/logout": RouteHandler{
 			Method:  "POST",
 			Handler: logoutHandler,
+			Role:    RoleViewer,
 		},
 	}
 )
@@ -254,12 +518,21 @@ type Server struct {
 	c   *Composer
 	r   *Renderer
 	m   *MediaManager
+	ar  *Archiver
+	b   *BackupScheduler
+	t   *TrashCollector
+	g   *MediaCollector
+	ma  Mailer
+	au  *AuditLog
+	ao  AuthOptions
+	cp  CorsPolicy
+	so  SecurityOptions
 	l   *zap.Logger
 	bp  *BufferPool
 	gzp *fs.GzipPool
 }
 
-func NewServer(co *Configurator, db DB, ca Cache, c *Composer, r *Renderer, m *MediaManager, l *zap.Logger) *Server {
+func NewServer(co *Configurator, db DB, ca Cache, c *Composer, r *Renderer, m *MediaManager, ar *Archiver, b *BackupScheduler, t *TrashCollector, g *MediaCollector, ma Mailer, au *AuditLog, ao AuthOptions, cp CorsPolicy, so SecurityOptions, l *zap.Logger) *Server {
 	return &Server{
 		db:  db,
 		ca:  ca,
@@ -267,6 +540,15 @@ func NewServer(co *Configurator, db DB, ca Cache, c *Composer, r *Renderer, m *M
 		c:   c,
 		r:   r,
 		m:   m,
+		ar:  ar,
+		b:   b,
+		t:   t,
+		g:   g,
+		ma:  ma,
+		au:  au,
+		ao:  ao,
+		cp:  cp,
+		so:  so,
 		l:   l,
 		bp:  NewBufferPool(32, 1024),
 		gzp: fs.NewGzipPool(6),
@@ -313,16 +595,21 @@ func (s *Server) NewRouter() http.Handler {
 		var h HandleFunc
 		{
 			h = f.Handler(s)
+			h = NewSecurityHeadersMiddleware(s.so, s.r)(h)
 			h = NewLoggingMiddleware(s.l)(h)
 			h = NewGzipMiddleware(s.gzp)(h)
 		}
 		r.HandleFunc(p, h).Methods(f.Method)
 	}
 
-	r.NotFoundHandler = &NotFoundHandler{
-		s: s,
+	var nf HandleFunc
+	{
+		nf = (&NotFoundHandler{s: s}).ServeHTTP
+		nf = NewSecurityHeadersMiddleware(s.so, s.r)(nf)
 	}
 
+	r.NotFoundHandler = http.HandlerFunc(nf)
+
 	return r
 }
 
@@ -345,9 +632,12 @@ func (s *Server) NewAdminRouter() http.Handler {
 		var h HandleFunc
 		{
 			h = f.Handler(s)
-			h = NewAuthorisationMiddleware(s.ca, sf)(h)
+			if s.au.covers(f) {
+				h = NewAuditMiddleware(s.au, p)(h)
+			}
+			h = NewAuthorisationMiddleware(s.db, f.Role, f.Scope, sf)(h)
 			h = NewLoggingMiddleware(s.l)(h)
-			h = NewCorsMiddleware()(h)
+			h = NewCorsMiddleware(s.cp)(h)
 			h = NewJsonMiddleware()(h)
 			h = NewGzipMiddleware(s.gzp)(h)
 		}
@@ -359,7 +649,7 @@ func (s *Server) NewAdminRouter() http.Handler {
 		{
 			h = f.Handler(s)
 			h = NewLoggingMiddleware(s.l)(h)
-			h = NewCorsMiddleware()(h)
+			h = NewCorsMiddleware(s.cp)(h)
 			h = NewJsonMiddleware()(h)
 			h = NewGzipMiddleware(s.gzp)(h)
 		}
@@ -426,16 +716,11 @@ func (s *Server) NewSetupRouter() (http.Handler, <-chan bool, chan<- bool) {
 				return
 			}
 
-			c, err := s.db.GetCredentials(ctx)
-			if err != nil {
-				redirectToSetup(w, r, err)
-				return
-			}
-
-			c.Email = m["email"]
-			c.Hash = string(hash)
+			a := NewAccount(m["email"], "", string(hash), RoleOwner)
 
-			err = s.db.PutCredentials(&c)
+			// Replacing rather than adding the owner lets a setup that
+			// failed at a later step be submitted again.
+			err = s.db.ReplaceAccounts([]Account{a})
 			if err != nil {
 				redirectToSetup(w, r, err)
 				return
@@ -529,7 +814,7 @@ func homeHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 		b := s.bp.Get()
 		defer s.bp.Put(b)
 
-		err := s.r.Render(b, &p, r.URL.Path)
+		err := s.r.Render(b, &p, r.URL.Path, cspNonce(r.Context()))
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -558,10 +843,14 @@ func projectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 
 		var p Page = s.c.GetProject(slug)
 
+		if p.Type == PageNotFound && s.redirect(w, r, RevisionProject, slug) {
+			return
+		}
+
 		b := s.bp.Get()
 		defer s.bp.Put(b)
 
-		err := s.r.Render(b, &p, r.URL.Path)
+		err := s.r.Render(b, &p, r.URL.Path, cspNonce(r.Context()))
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -598,10 +887,14 @@ func pageHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 			}
 		}
 
+		if p.Type == PageNotFound && s.redirect(w, r, RevisionContent, slug) {
+			return
+		}
+
 		b := s.bp.Get()
 		defer s.bp.Put(b)
 
-		err := s.r.Render(b, &p, r.URL.Path)
+		err := s.r.Render(b, &p, r.URL.Path, cspNonce(r.Context()))
 
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -682,6 +975,7 @@ func getProjectsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 				Title:    p.Title,
 				Subtitle: p.Subtitle,
 				Image:    i,
+				Status:   string(p.Status),
 			})
 		}
 
@@ -689,6 +983,88 @@ func getProjectsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+func getProjectOrderHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
+
+		c, err := s.db.GetConfiguration(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		ps, err := s.db.GetProjects(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var slugs = make([]string, 0, len(ps))
+		for _, p := range ps {
+			slugs = append(slugs, p.Slug)
+		}
+
+		mode := c.ProjectOrder
+		if mode == "" {
+			mode = ProjectOrderManual
+		}
+
+		writeResponse(w, ProjectOrder_{
+			Mode:  string(mode),
+			Slugs: slugs,
+		}, nil)
+	}
+}
+
+func updateProjectOrderHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
+
+		var req ProjectOrderRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		if req.Mode != "" && !isProjectOrder(ProjectOrder(req.Mode)) {
+			writeResponse(w, nil, ErrInvalidProjectOrder)
+			return
+		}
+
+		if len(req.Slugs) > 0 {
+			err := s.db.ReorderProjects(req.Slugs)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+		}
+
+		if req.Mode != "" {
+			c, err := s.db.GetConfiguration(ctx)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			c.ProjectOrder = ProjectOrder(req.Mode)
+
+			err = s.db.PutConfiguration(&c)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			err = s.reconfigure(c)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
 func getContentsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var ctx = context.TODO()
@@ -706,6 +1082,7 @@ func getContentsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 
 				Title:    c.Title,
 				Subtitle: c.Subtitle,
+				Status:   string(c.Status),
 			})
 		}
 
@@ -803,16 +1180,10 @@ func getMetaHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 
 func getCredentialsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
-
-		c, err := s.db.GetCredentials(ctx)
-		if err != nil {
-			writeResponse(w, nil, err)
-			return
-		}
+		a, _ := accountFrom(r.Context())
 
 		req := UpdateCredentialsRequest{
-			Email: c.Email,
+			Email: a.Email,
 		}
 
 		writeResponse(w, req, nil)
@@ -836,7 +1207,7 @@ func updateUserHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 		}
 
 		if req.Image[0].Removed {
-			s.m.Delete(&u.Image)
+			u.Image = Media{}
 		} else {
 			if len(req.Image[0].File.Data) > 0 {
 				m, err := s.m.Save(&req.Image[0].File)
@@ -850,7 +1221,7 @@ func updateUserHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 		}
 
 		if req.Logo[0].Removed {
-			s.m.Delete(&u.Logo)
+			u.Logo = Media{}
 		} else {
 			if len(req.Logo[0].File.Data) > 0 {
 				m, err := s.m.Save(&req.Logo[0].File)
@@ -1025,32 +1396,55 @@ func updateMetaHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// updateCredentialsHandler changes the email and password of the current
+// account. It asks for the current password, and a second factor code when
+// two-factor authentication is enabled, so a stolen token cannot take the
+// account over.
 func updateCredentialsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
-
 		var req UpdateCredentialsRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		c, err := s.db.GetCredentials(ctx)
+		if strings.TrimSpace(req.Email) == "" || req.Password == "" {
+			writeResponse(w, nil, ErrCredentialsEmpty)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		a, _ := accountFrom(r.Context())
+
+		a, err = s.db.UpdateAccount(a.ID, func(a *Account) error {
+			if bcrypt.CompareHashAndPassword([]byte(a.Hash), []byte(req.CurrentPassword)) != nil {
+				return ErrWrongPassword
+			}
+
+			if a.TOTP != nil && a.TOTP.Enabled && !checkSecondFactor(a.TOTP, req.Code, time.Now()) {
+				return ErrInvalidTOTPCode
+			}
+
+			a.Email = req.Email
+			a.Hash = string(hash)
+
+			return nil
+		})
+
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		c.Email = req.Email
-		c.Hash = string(hash)
+		// A new password signs out every other browser.
+		sn, _ := sessionFrom(r.Context())
 
-		err = s.db.PutCredentials(&c)
+		err = s.db.DeleteSessions(a.ID, sn.ID)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
@@ -1060,189 +1454,1274 @@ func updateCredentialsHandler(s *Server) func(http.ResponseWriter, *http.Request
 	}
 }
 
-func getProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func getAccountsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ctx  = context.TODO()
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
-
-		p, err := s.db.GetProject(ctx, slug)
+		as, err := s.db.GetAccounts(context.TODO())
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		var style string
-		{
-			if p.Style == StyleDark {
-				style = "dark"
-			} else {
-				style = "light"
-			}
-		}
-
-		req := UpdateProjectRequest{
-			Slug: p.Slug,
-
-			Title:        p.Title,
-			Subtitle:     p.Subtitle,
-			About:        p.About,
-			Tags:         p.Tags,
-			Technologies: p.Technologies,
-			References:   p.References,
-			Image: []Media_{
-				Media_{
-					Name:     p.Image.Name,
-					Caption:  p.Image.Caption,
-					Resource: "image",
-				},
-			},
-			Logo: []Media_{
-				Media_{
-					Name:     p.Logo.Name,
-					Caption:  p.Logo.Caption,
-					Resource: "logo",
-				},
-			},
-			Client: struct {
-				Name  string   `json:"name"`
-				About string   `json:"about"`
-				Image []Media_ `json:"image"`
-			}{
-				Name:  p.Client.Name,
-				About: p.Client.About,
-				Image: []Media_{
-					Media_{
-						Name:     p.Client.Image.Name,
-						Caption:  p.Client.Image.Caption,
-						Resource: p.Client.Image.Path,
-					},
-				},
-			},
-			Style: style,
-		}
-
-		var media = make([]Media_, 0, len(p.Images))
-		for _, m := range p.Images {
-			media = append(media, Media_{
-				Name:     m.Name,
-				Caption:  m.Caption,
-				Resource: m.Path,
-				Uploaded: true,
+		var as_ = make([]Account_, 0, len(as))
+		for _, v := range as {
+			as_ = append(as_, Account_{
+				ID:      v.ID,
+				Email:   v.Email,
+				Name:    v.Name,
+				Role:    string(v.Role),
+				Created: v.Created,
+				TOTP:    v.TOTP != nil && v.TOTP.Enabled,
 			})
 		}
 
-		req.Media = media
-
-		writeResponse(w, req, nil)
+		writeResponse(w, as_, nil)
 	}
 }
 
-func createProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// inviteAccountHandler creates an account with a generated password. The
+// password is only returned here, the owner passes it on to the invitee.
+func inviteAccountHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req CreateProjectRequest
+		var req InviteAccountRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		var style ProjectStyle
-		{
-			if req.Style == "light" {
-				style = StyleLight
+		if normalizeEmail(req.Email) == "" {
+			writeResponse(w, nil, ErrSetupEmpty)
+			return
+		}
+
+		if !isRole(Role(req.Role)) {
+			writeResponse(w, nil, ErrInvalidRole)
+			return
+		}
+
+		password := GenerateSecret(12)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		a := NewAccount(req.Email, req.Name, string(hash), Role(req.Role))
+
+		err = s.db.PutAccount(&a)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, Invitation_{
+			Account: Account_{
+				ID:      a.ID,
+				Email:   a.Email,
+				Name:    a.Name,
+				Role:    string(a.Role),
+				Created: a.Created,
+			},
+			Password: password,
+		}, nil)
+	}
+}
+
+func updateAccountHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vars = mux.Vars(r)
+
+		var req UpdateAccountRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		if req.Role != "" && !isRole(Role(req.Role)) {
+			writeResponse(w, nil, ErrInvalidRole)
+			return
+		}
+
+		// Only the fields edited here change, so a sign-in updating the
+		// second factor or password at the same time is not undone.
+		_, err := s.db.UpdateAccount(vars["id"], func(a *Account) error {
+			if req.Role != "" {
+				a.Role = Role(req.Role)
+			}
+
+			if req.Email != "" {
+				a.Email = req.Email
+			}
+
+			a.Name = req.Name
+
+			return nil
+		})
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func deleteAccountHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vars = mux.Vars(r)
+
+		err := s.db.DeleteAccount(vars["id"])
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		err = s.db.DeleteSessions(vars["id"], "")
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		ks, err := s.db.GetAPIKeys(context.TODO())
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		for _, k := range ks {
+			if k.Account != vars["id"] {
+				continue
+			}
+
+			if err := s.db.DeleteAPIKey(k.ID); err != nil && err != ErrAPIKeyNotFound {
+				writeResponse(w, nil, err)
+				return
+			}
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func getAPIKeysHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ks, err := s.db.GetAPIKeys(context.TODO())
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var ks_ = make([]APIKey_, 0, len(ks))
+		for _, v := range ks {
+			ks_ = append(ks_, newAPIKey_(v))
+		}
+
+		writeResponse(w, ks_, nil)
+	}
+}
+
+// createAPIKeyHandler creates a key acting for the current account. The key
+// is only returned here, just its hash is kept.
+func createAPIKeyHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateAPIKeyRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		if len(req.Scopes) == 0 {
+			writeResponse(w, nil, ErrInvalidScope)
+			return
+		}
+
+		var scopes = make([]Scope, 0, len(req.Scopes))
+		for _, v := range req.Scopes {
+			if !isScope(Scope(v)) {
+				writeResponse(w, nil, ErrInvalidScope)
+				return
+			}
+
+			scopes = append(scopes, Scope(v))
+		}
+
+		a, _ := accountFrom(r.Context())
+
+		k, key := NewAPIKey(a.ID, req.Name, scopes)
+
+		err := s.db.PutAPIKey(&k)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, NewAPIKey_{
+			APIKey: newAPIKey_(k),
+			Key:    key,
+		}, nil)
+	}
+}
+
+func revokeAPIKeyHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vars = mux.Vars(r)
+
+		err := s.db.DeleteAPIKey(vars["id"])
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func newAPIKey_(k APIKey) APIKey_ {
+	var scopes = make([]string, 0, len(k.Scopes))
+	for _, v := range k.Scopes {
+		scopes = append(scopes, string(v))
+	}
+
+	return APIKey_{
+		ID:       k.ID,
+		Account:  k.Account,
+		Name:     k.Name,
+		Scopes:   scopes,
+		Created:  k.Created,
+		LastUsed: k.LastUsed,
+	}
+}
+
+// getAuditHandler pages through the audit log, newest first. Pass the next
+// cursor of a page as before to get the one after it.
+func getAuditHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			q = r.URL.Query()
+			f = AuditFilter{
+				Actor:  q.Get("actor"),
+				Action: q.Get("action"),
+				Target: q.Get("target"),
+			}
+			err error
+		)
+
+		for _, v := range []struct {
+			key string
+			t   *time.Time
+		}{{"since", &f.Since}, {"until", &f.Until}} {
+			if q.Get(v.key) == "" {
+				continue
+			}
+
+			if *v.t, err = time.Parse(time.RFC3339, q.Get(v.key)); err != nil {
+				writeResponse(w, nil, ErrInvalidAuditFilter)
+				return
+			}
+		}
+
+		if q.Get("before") != "" {
+			if f.Before, err = strconv.ParseUint(q.Get("before"), 10, 64); err != nil {
+				writeResponse(w, nil, ErrInvalidAuditFilter)
+				return
+			}
+		}
+
+		if q.Get("limit") != "" {
+			if f.Limit, err = strconv.Atoi(q.Get("limit")); err != nil {
+				writeResponse(w, nil, ErrInvalidAuditFilter)
+				return
+			}
+		}
+
+		es, err := s.db.GetAuditEntries(context.TODO(), f)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var p = AuditPage_{
+			Entries: make([]AuditEntry_, 0, len(es)),
+		}
+
+		for _, e := range es {
+			p.Entries = append(p.Entries, AuditEntry_{
+				ID:      e.ID,
+				Time:    e.Time,
+				Actor:   e.Actor,
+				APIKey:  e.APIKey,
+				Action:  e.Action,
+				Target:  e.Target,
+				IP:      e.IP,
+				Changes: e.Changes,
+				Status:  e.Status,
+				Error:   e.Error,
+			})
+		}
+
+		// A full page may have more after it.
+		if len(es) > 0 && len(es) == f.limit() {
+			p.Next = es[len(es)-1].ID
+		}
+
+		writeResponse(w, p, nil)
+	}
+}
+
+func getSessionsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			a, _  = accountFrom(r.Context())
+			sn, _ = sessionFrom(r.Context())
+		)
+
+		ss, err := s.db.GetSessions(context.TODO(), a.ID)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		sort.Slice(ss, func(i, j int) bool {
+			return ss[i].LastSeen.After(ss[j].LastSeen)
+		})
+
+		var ss_ = make([]Session_, 0, len(ss))
+		for _, v := range ss {
+			if s.sessionExpired(v) {
+				continue
+			}
+
+			ss_ = append(ss_, Session_{
+				ID:        v.ID,
+				Created:   v.Created,
+				LastSeen:  v.LastSeen,
+				IP:        v.IP,
+				UserAgent: v.UserAgent,
+				Current:   v.ID == sn.ID,
+			})
+		}
+
+		writeResponse(w, ss_, nil)
+	}
+}
+
+// revokeSessionHandler signs out one of the current account's sessions.
+func revokeSessionHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			vars = mux.Vars(r)
+			a, _ = accountFrom(r.Context())
+		)
+
+		sn, err := s.db.GetSession(context.TODO(), vars["id"])
+		if err == nil && sn.Account != a.ID {
+			err = ErrSessionNotFound
+		}
+
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		err = s.db.DeleteSession(sn.ID)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func revokeOtherSessionsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			a, _  = accountFrom(r.Context())
+			sn, _ = sessionFrom(r.Context())
+		)
+
+		err := s.db.DeleteSessions(a.ID, sn.ID)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func getProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ctx  = context.TODO()
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		p, err := s.db.GetProject(ctx, slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var style string
+		{
+			if p.Style == StyleDark {
+				style = "dark"
+			} else {
+				style = "light"
+			}
+		}
+
+		req := UpdateProjectRequest{
+			Slug: p.Slug,
+
+			Title:        p.Title,
+			Subtitle:     p.Subtitle,
+			About:        p.About,
+			Tags:         p.Tags,
+			Technologies: p.Technologies,
+			References:   p.References,
+			Image: []Media_{
+				Media_{
+					Name:     p.Image.Name,
+					Caption:  p.Image.Caption,
+					Resource: "image",
+				},
+			},
+			Logo: []Media_{
+				Media_{
+					Name:     p.Logo.Name,
+					Caption:  p.Logo.Caption,
+					Resource: "logo",
+				},
+			},
+			Client: struct {
+				Name  string   `json:"name"`
+				About string   `json:"about"`
+				Image []Media_ `json:"image"`
+			}{
+				Name:  p.Client.Name,
+				About: p.Client.About,
+				Image: []Media_{
+					Media_{
+						Name:     p.Client.Image.Name,
+						Caption:  p.Client.Image.Caption,
+						Resource: p.Client.Image.Path,
+					},
+				},
+			},
+			Style:     style,
+			Status:    string(p.Status),
+			PublishAt: p.PublishAt,
+		}
+
+		var media = make([]Media_, 0, len(p.Images))
+		for _, m := range p.Images {
+			media = append(media, Media_{
+				Name:     m.Name,
+				Caption:  m.Caption,
+				Resource: m.Path,
+				Uploaded: true,
+			})
+		}
+
+		req.Media = media
+
+		writeResponse(w, req, nil)
+	}
+}
+
+func createProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateProjectRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		var style ProjectStyle
+		{
+			if req.Style == "light" {
+				style = StyleLight
+			} else {
+				style = StyleDark
+			}
+		}
+
+		p := Project{
+			Slug: GenerateSlug(req.Title),
+
+			Title:    req.Title,
+			Subtitle: req.Subtitle,
+			About:    req.About,
+
+			Published: time.Now(),
+
+			Tags:         req.Tags,
+			Technologies: req.Technologies,
+			References:   req.References,
+
+			Client: Client{
+				Name:  req.Client.Name,
+				About: req.Client.About,
+			},
+
+			Imported: Imported{},
+			Style:    style,
+		}
+
+		err := setStatus(&p.Status, &p.PublishAt, &p.Published, req.Status, req.PublishAt)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		ps, err := s.db.GetProjects(context.TODO())
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		p.Position = nextProjectPosition(ps)
+
+		err = s.db.CreateProject(&p)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		if req.Image[0].Removed {
+			p.Image = Media{}
+		} else {
+			if len(req.Image[0].File.Data) > 0 {
+				m, err := s.m.Save(&req.Image[0].File)
+				if err == nil {
+					m.Name = req.Image[0].Name
+					m.Caption = req.Image[0].Caption
+
+					p.Image = *m
+				}
+			}
+		}
+
+		if req.Logo[0].Removed {
+			p.Logo = Media{}
+		} else {
+			if len(req.Logo[0].File.Data) > 0 {
+				m, err := s.m.Save(&req.Logo[0].File)
+				if err == nil {
+					m.Name = req.Logo[0].Name
+					m.Caption = req.Logo[0].Caption
+
+					p.Logo = *m
+				}
+			}
+		}
+
+		if req.Client.Image[0].Removed {
+			p.Client.Image = Media{}
+		} else {
+			if len(req.Client.Image[0].File.Data) > 0 {
+				me, err := s.m.Save(&req.Client.Image[0].File)
+				if err == nil {
+					me.Name = req.Client.Image[0].Name
+					me.Caption = req.Client.Image[0].Caption
+
+					p.Client.Image = *me
+				}
+			}
+		}
+
+		media := make([]Media, 0)
+		for _, m := range req.Media {
+			if len(m.File.Data) > 0 {
+				me, err := s.m.Save(&m.File)
+				if err == nil {
+					me.Name = m.Name
+					me.Caption = m.Caption
+
+					media = append(media, *me)
+				}
+			}
+		}
+
+		p.Images = media
+
+		err = s.saveProject(r, "", &p)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func updateProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ctx  = context.TODO()
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		var req UpdateProjectRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		p, err := s.db.GetProject(ctx, slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		if to := GenerateSlug(req.Slug); to != "" {
+			p.Slug = to
+		}
+
+		var style ProjectStyle
+		{
+			if req.Style == "light" {
+				style = StyleLight
+			} else {
+				style = StyleDark
+			}
+		}
+
+		p.Title = req.Title
+		p.Subtitle = req.Subtitle
+		p.About = req.About
+
+		p.Tags = req.Tags
+		p.Technologies = req.Technologies
+		p.References = req.References
+
+		p.Client.Name = req.Client.Name
+		p.Client.About = req.Client.About
+
+		p.Images = s.diffMedia(p.Images, req.Media)
+
+		p.Style = style
+
+		err = setStatus(&p.Status, &p.PublishAt, &p.Published, req.Status, req.PublishAt)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		// Replaced and removed files stay on disk, revisions may still use
+		// them. The media collector removes them once nothing does.
+		if req.Image[0].Removed {
+			p.Image = Media{}
+		} else {
+			if len(req.Image[0].File.Data) > 0 {
+				me, err := s.m.Save(&req.Image[0].File)
+				if err == nil {
+					p.Image = *me
+				}
+			}
+
+			p.Image.Name = req.Image[0].Name
+			p.Image.Caption = req.Image[0].Caption
+		}
+
+		if req.Logo[0].Removed {
+			p.Logo = Media{}
+		} else {
+			if len(req.Logo[0].File.Data) > 0 {
+				me, err := s.m.Save(&req.Logo[0].File)
+				if err == nil {
+					p.Logo = *me
+				}
+			}
+
+			p.Logo.Name = req.Logo[0].Name
+			p.Logo.Caption = req.Logo[0].Caption
+		}
+
+		if req.Client.Image[0].Removed {
+			p.Client.Image = Media{}
+		} else {
+			if len(req.Client.Image[0].File.Data) > 0 {
+				me, err := s.m.Save(&req.Client.Image[0].File)
+				if err == nil {
+					p.Client.Image = *me
+				}
+			}
+
+			p.Client.Image.Name = req.Client.Image[0].Name
+			p.Client.Image.Caption = req.Client.Image[0].Caption
+		}
+
+		err = s.saveProject(r, slug, &p)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func deleteProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		err := s.db.DeleteProject(slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func getContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ctx  = context.TODO()
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		c, err := s.db.GetContent(ctx, slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var ps = make([]Paragraph_, 0, len(c.Paragraphs))
+		for _, p := range c.Paragraphs {
+			ps = append(ps, Paragraph_{
+				Resource: p.Slug,
+				Title:    p.Title,
+				Content:  p.Content,
+				Media: []Media_{
+					Media_{
+						Name:     p.Media.Name,
+						Caption:  p.Media.Caption,
+						Resource: p.Media.Path,
+					},
+				},
+			})
+		}
+
+		req := UpdateContentRequest{
+			Slug: c.Slug,
+
+			Title:        c.Title,
+			Subtitle:     c.Subtitle,
+			Paragraphs:   ps,
+			Tags:         c.Tags,
+			Technologies: c.Technologies,
+			References:   c.References,
+			Status:       string(c.Status),
+			PublishAt:    c.PublishAt,
+		}
+
+		writeResponse(w, req, nil)
+	}
+}
+
+func createContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateContentRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		c := Content{
+			Slug: GenerateSlug(req.Title),
+
+			Title:    req.Title,
+			Subtitle: req.Subtitle,
+
+			Published: time.Now(),
+
+			Tags:         req.Tags,
+			Technologies: req.Technologies,
+			References:   req.References,
+		}
+
+		err := setStatus(&c.Status, &c.PublishAt, &c.Published, req.Status, req.PublishAt)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		err = s.db.CreateContent(&c)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		paragraphs := make([]Paragraph, 0, len(req.Paragraphs))
+		for _, v := range req.Paragraphs {
+			p := Paragraph{
+				Slug:    v.Resource,
+				Title:   v.Title,
+				Content: v.Content,
+			}
+			if v.Media[0].Removed {
+				p.Media = Media{}
 			} else {
-				style = StyleDark
+				if len(v.Media[0].File.Data) > 0 {
+					me, err := s.m.Save(&v.Media[0].File)
+					if err == nil {
+						me.Name = v.Media[0].Name
+						me.Caption = v.Media[0].Caption
+
+						p.Media = *me
+					}
+				}
+			}
+
+			paragraphs = append(paragraphs, p)
+		}
+
+		c.Paragraphs = paragraphs
+
+		err = s.saveContent(r, "", &c, &Route{
+			Title: c.Title,
+			Slug:  c.Slug,
+		})
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func updateContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			ctx  = context.TODO()
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		var req UpdateContentRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		c, err := s.db.GetContent(ctx, slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		if to := GenerateSlug(req.Slug); to != "" {
+			c.Slug = to
+		}
+
+		c.Title = req.Title
+		c.Subtitle = req.Subtitle
+
+		c.Tags = req.Tags
+		c.Technologies = req.Technologies
+		c.References = req.References
+
+		err = setStatus(&c.Status, &c.PublishAt, &c.Published, req.Status, req.PublishAt)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		c.Paragraphs = s.diffParagraphs(c.Paragraphs, req.Paragraphs)
+
+		err = s.saveContent(r, slug, &c, nil)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func deleteContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			vars = mux.Vars(r)
+			slug = vars["slug"]
+		)
+
+		err := s.db.DeleteContent(slug)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func getRevisionsHandler(kind RevisionKind) func(*Server) func(http.ResponseWriter, *http.Request) {
+	return func(s *Server) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var (
+				ctx  = context.TODO()
+				vars = mux.Vars(r)
+				slug = vars["slug"]
+			)
+
+			rs, err := s.db.GetRevisions(ctx, kind, slug)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			var rs_ = make([]Revision_, 0, len(rs))
+			for _, v := range rs {
+				rs_ = append(rs_, Revision_{
+					ID:      v.ID,
+					Author:  v.Author,
+					Created: v.Created,
+				})
 			}
+
+			writeResponse(w, rs_, nil)
 		}
+	}
+}
 
-		p := Project{
-			Slug: GenerateSlug(req.Title),
+func diffRevisionsHandler(kind RevisionKind) func(*Server) func(http.ResponseWriter, *http.Request) {
+	return func(s *Server) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var (
+				ctx  = context.TODO()
+				vars = mux.Vars(r)
+				slug = vars["slug"]
+				q    = r.URL.Query()
+			)
 
-			Title:    req.Title,
-			Subtitle: req.Subtitle,
-			About:    req.About,
+			from, err := strconv.ParseUint(q.Get("from"), 10, 64)
+			if err != nil {
+				writeResponse(w, nil, ErrRevisionNotFound)
+				return
+			}
 
-			Published: time.Now(),
+			a, err := s.db.GetRevision(ctx, kind, slug, from)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
 
-			Tags:         req.Tags,
-			Technologies: req.Technologies,
-			References:   req.References,
+			var b Revision
+			{
+				if q.Get("to") == "" {
+					b, err = s.currentRevision(kind, slug)
+				} else {
+					var to uint64
+					to, err = strconv.ParseUint(q.Get("to"), 10, 64)
+					if err != nil {
+						err = ErrRevisionNotFound
+					} else {
+						b, err = s.db.GetRevision(ctx, kind, slug, to)
+					}
+				}
+			}
 
-			Client: Client{
-				Name:  req.Client.Name,
-				About: req.Client.About,
-			},
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
 
-			Imported: Imported{},
-			Style:    style,
+			changes, err := DiffRevisions(a, b)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			writeResponse(w, changes, nil)
+		}
+	}
+}
+
+func restoreRevisionHandler(kind RevisionKind) func(*Server) func(http.ResponseWriter, *http.Request) {
+	return func(s *Server) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var (
+				ctx  = context.TODO()
+				vars = mux.Vars(r)
+				slug = vars["slug"]
+			)
+
+			id, err := strconv.ParseUint(vars["id"], 10, 64)
+			if err != nil {
+				writeResponse(w, nil, ErrRevisionNotFound)
+				return
+			}
+
+			rev, err := s.db.GetRevision(ctx, kind, slug, id)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			switch kind {
+			case RevisionProject:
+				var p Project
+				if err = json.Unmarshal(rev.Data, &p); err != nil {
+					break
+				}
+
+				p.Slug = slug
+
+				// The position belongs to the ordering, not the revision.
+				if current, err := s.db.GetProject(ctx, slug); err == nil {
+					p.Position = current.Position
+				}
+
+				err = s.saveProject(r, slug, &p)
+			case RevisionContent:
+				var c Content
+				if err = json.Unmarshal(rev.Data, &c); err != nil {
+					break
+				}
+
+				c.Slug = slug
+
+				err = s.saveContent(r, slug, &c, nil)
+			}
+
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			writeResponse(w, true, nil)
 		}
+	}
+}
+
+func getTrashHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
 
-		err := s.db.CreateProject(&p)
+		items, err := s.db.GetTrash(ctx)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		if req.Image[0].Removed {
-			p.Image = Media{}
-		} else {
-			if len(req.Image[0].File.Data) > 0 {
-				m, err := s.m.Save(&req.Image[0].File)
-				if err == nil {
-					m.Name = req.Image[0].Name
-					m.Caption = req.Image[0].Caption
+		var items_ = make([]TrashItem_, 0, len(items))
+		for _, v := range items {
+			items_ = append(items_, TrashItem_{
+				ID:      v.ID,
+				Kind:    string(v.Kind),
+				Slug:    v.Slug,
+				Title:   v.Title,
+				Deleted: v.Deleted,
+				Media:   len(v.Media),
+			})
+		}
 
-					p.Image = *m
-				}
-			}
+		writeResponse(w, items_, nil)
+	}
+}
+
+func restoreTrashHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vars = mux.Vars(r)
+
+		id, err := strconv.ParseUint(vars["id"], 10, 64)
+		if err != nil {
+			writeResponse(w, nil, ErrTrashItemNotFound)
+			return
 		}
 
-		if req.Logo[0].Removed {
-			p.Logo = Media{}
-		} else {
-			if len(req.Logo[0].File.Data) > 0 {
-				m, err := s.m.Save(&req.Logo[0].File)
-				if err == nil {
-					m.Name = req.Logo[0].Name
-					m.Caption = req.Logo[0].Caption
+		_, err = s.db.RestoreTrashItem(id)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
 
-					p.Logo = *m
-				}
+		writeResponse(w, true, nil)
+	}
+}
+
+func purgeTrashHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var vars = mux.Vars(r)
+
+		id, err := strconv.ParseUint(vars["id"], 10, 64)
+		if err != nil {
+			writeResponse(w, nil, ErrTrashItemNotFound)
+			return
+		}
+
+		err = s.t.Purge(context.TODO(), id)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, true, nil)
+	}
+}
+
+func getCacheStatsHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.ca.Stats()
+
+		writeResponse(w, CacheStats_{
+			Entries:   st.Entries,
+			Bytes:     st.Bytes,
+			Hits:      st.Hits,
+			Misses:    st.Misses,
+			Evictions: st.Evictions,
+		}, nil)
+	}
+}
+
+func collectMediaHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.g.Collect(context.TODO())
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		writeResponse(w, MediaCollection_{
+			Files:      c.Files,
+			Bytes:      c.Bytes,
+			Quarantine: c.Quarantine,
+		}, nil)
+	}
+}
+
+// checkHandler runs the consistency checker, repairing the safe cases when
+// repair is set.
+func checkHandler(repair bool) func(*Server) func(http.ResponseWriter, *http.Request) {
+	return func(s *Server) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			problems, err := NewChecker(s.db, s.l).Check(context.TODO(), repair)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			var problems_ = make([]Problem_, 0, len(problems))
+			for _, v := range problems {
+				problems_ = append(problems_, Problem_{
+					Type:     string(v.Type),
+					Subject:  v.Subject,
+					Detail:   v.Detail,
+					Repaired: v.Repaired,
+				})
 			}
+
+			writeResponse(w, problems_, nil)
 		}
+	}
+}
 
-		if req.Client.Image[0].Removed {
-			p.Client.Image = Media{}
-		} else {
-			if len(req.Client.Image[0].File.Data) > 0 {
-				me, err := s.m.Save(&req.Client.Image[0].File)
-				if err == nil {
-					me.Name = req.Client.Image[0].Name
-					me.Caption = req.Client.Image[0].Caption
+func getMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
 
-					p.Client.Image = *me
+		rt, err := s.db.GetRoutes(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		m, err := s.db.GetMenu(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		var k []int = make([]int, 0, len(m))
+		for v := range m {
+			k = append(k, v)
+		}
+		sort.Ints(k)
+
+		var (
+			rt_ = make([]string, 0, len(rt)-len(m))
+			m_  = make([]string, 0, len(m))
+		)
+
+		for _, r := range rt {
+			var found bool
+			for _, v := range m {
+				if v.Slug == r.Slug {
+					found = true
+					break
 				}
 			}
+			if !found {
+				rt_ = append(rt_, r.Slug)
+			}
+		}
+		for _, i := range k {
+			m_ = append(m_, m[i].Slug)
 		}
 
-		media := make([]Media, 0)
-		for _, m := range req.Media {
-			if len(m.File.Data) > 0 {
-				me, err := s.m.Save(&m.File)
-				if err == nil {
-					me.Name = m.Name
-					me.Caption = m.Caption
+		req := Menu_{
+			Routes: rt_,
+			Added:  m_,
+		}
+
+		writeResponse(w, req, nil)
+	}
+}
+
+func addToMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
+
+		var req AddToMenuRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		rt, err := s.db.GetRoutes(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		m, err := s.db.GetMenu(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
+
+		if _, ok := rt[req.Slug]; !ok {
+			writeResponse(w, nil, err)
+			return
+		}
 
-					media = append(media, *me)
-				}
+		var keys []int = make([]int, 0, len(m))
+		for k, v := range m {
+			if v.Slug == req.Slug {
+				writeResponse(w, nil, err)
+				return
 			}
+			keys = append(keys, k)
 		}
 
-		p.Images = media
+		sort.Ints(keys)
+
+		m[keys[(len(keys)-1)]+1] = rt[req.Slug]
 
-		err = s.db.PutProject(&p)
+		err = s.db.PutMenu(&m)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
@@ -1252,99 +2731,51 @@ func createProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func updateProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func deleteFromMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ctx  = context.TODO()
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
+		var ctx = context.TODO()
 
-		var req UpdateProjectRequest
+		var req RemoveFromMenuRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		p, err := s.db.GetProject(ctx, slug)
+		m, err := s.db.GetMenu(ctx)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		var style ProjectStyle
-		{
-			if req.Style == "light" {
-				style = StyleLight
-			} else {
-				style = StyleDark
-			}
-		}
-
-		p.Title = req.Title
-		p.Subtitle = req.Subtitle
-		p.About = req.About
-
-		p.Tags = req.Tags
-		p.Technologies = req.Technologies
-		p.References = req.References
-
-		p.Client.Name = req.Client.Name
-		p.Client.About = req.Client.About
-
-		p.Images = s.diffMedia(p.Images, req.Media)
-
-		p.Style = style
-
-		if req.Image[0].Removed {
-			s.m.Delete(&p.Image)
-		} else {
-			if len(req.Image[0].File.Data) > 0 {
-				s.m.Delete(&p.Image)
+		var (
+			i    int   = -1
+			keys []int = make([]int, 0, len(m))
+		)
 
-				me, err := s.m.Save(&req.Image[0].File)
-				if err == nil {
-					p.Image = *me
-				}
+		for k, v := range m {
+			if v.Slug == req.Slug {
+				i = k
 			}
-
-			p.Image.Name = req.Image[0].Name
-			p.Image.Caption = req.Image[0].Caption
+			keys = append(keys, k)
 		}
 
-		if req.Logo[0].Removed {
-			s.m.Delete(&p.Logo)
-		} else {
-			if len(req.Logo[0].File.Data) > 0 {
-				s.m.Delete(&p.Logo)
-
-				me, err := s.m.Save(&req.Logo[0].File)
-				if err == nil {
-					p.Logo = *me
-				}
-			}
-
-			p.Logo.Name = req.Logo[0].Name
-			p.Logo.Caption = req.Logo[0].Caption
+		if i == -1 {
+			writeResponse(w, nil, err)
+			return
 		}
 
-		if req.Client.Image[0].Removed {
-			s.m.Delete(&p.Client.Image)
-		} else {
-			if len(req.Client.Image[0].File.Data) > 0 {
-				s.m.Delete(&p.Client.Image)
+		sort.Ints(keys)
 
-				me, err := s.m.Save(&req.Client.Image[0].File)
-				if err == nil {
-					p.Client.Image = *me
-				}
+		for k := 0; k < len(keys)-1; k++ {
+			if k == i {
+				m[k] = m[k+1]
+				i = k + 1
 			}
-
-			p.Client.Image.Name = req.Client.Image[0].Name
-			p.Client.Image.Caption = req.Client.Image[0].Caption
 		}
 
-		err = s.db.PutProject(&p)
+		delete(m, i)
+
+		err = s.db.PutMenu(&m)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
@@ -1354,343 +2785,396 @@ func updateProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func deleteProjectHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func siteHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
+		var ctx = context.TODO()
 
-		err := s.db.DeleteProject(slug)
+		c, err := s.db.GetConfiguration(ctx)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		writeResponse(w, true, nil)
+		writeResponse(w, c.Meta.Site, nil)
 	}
 }
 
-func getContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func exportHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ctx  = context.TODO()
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
+		s.liftDeadlines(w)
 
-		c, err := s.db.GetContent(ctx, slug)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"showcase-%s.tar.gz\"", time.Now().Format("20060102-150405")))
+
+		err := s.ar.Export(context.TODO(), w)
+		if err != nil {
+			s.l.Error("cannot export archive", zap.Error(err))
+		}
+	}
+}
+
+func importHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ctx = context.TODO()
+
+		s.liftDeadlines(w)
+
+		m, err := s.ar.Import(ctx, r.Body)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		var ps = make([]Paragraph_, 0, len(c.Paragraphs))
-		for _, p := range c.Paragraphs {
-			ps = append(ps, Paragraph_{
-				Resource: p.Slug,
-				Title:    p.Title,
-				Content:  p.Content,
-				Media: []Media_{
-					Media_{
-						Name:     p.Media.Name,
-						Caption:  p.Media.Caption,
-						Resource: p.Media.Path,
-					},
-				},
-			})
+		c, err := s.db.GetConfiguration(ctx)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
 		}
 
-		req := UpdateContentRequest{
-			Slug: c.Slug,
-
-			Title:        c.Title,
-			Subtitle:     c.Subtitle,
-			Paragraphs:   ps,
-			Tags:         c.Tags,
-			Technologies: c.Technologies,
-			References:   c.References,
+		err = s.reconfigure(c)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
 		}
 
-		writeResponse(w, req, nil)
+		writeResponse(w, m, nil)
 	}
 }
 
-func createContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func backupHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req CreateContentRequest
+		s.liftDeadlines(w)
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s%s%s\"", BackupPrefix, time.Now().Format(BackupTimeFormat), s.b.Extension()))
+
+		_, err := s.b.WriteTo(w)
+		if err != nil {
+			s.l.Error("cannot stream backup", zap.Error(err))
+		}
+	}
+}
+
+func loginHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LoginRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		c := Content{
-			Slug: GenerateSlug(req.Title),
-
-			Title:    req.Title,
-			Subtitle: req.Subtitle,
-
-			Published: time.Now(),
+		var (
+			ctx  = context.TODO()
+			now  = time.Now()
+			keys = s.loginKeys(r, req.Email)
+		)
 
-			Tags:         req.Tags,
-			Technologies: req.Technologies,
-			References:   req.References,
+		if s.loginThrottled(w, ctx, keys, now) {
+			return
 		}
 
-		err := s.db.CreateContent(&c)
-		if err != nil {
+		a, err := s.db.GetAccountByEmail(ctx, req.Email)
+		if err != nil && err != ErrAccountNotFound {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		paragraphs := make([]Paragraph, 0, len(req.Paragraphs))
-		for _, v := range req.Paragraphs {
-			p := Paragraph{
-				Slug:    v.Resource,
-				Title:   v.Title,
-				Content: v.Content,
-			}
-			if v.Media[0].Removed {
-				p.Media = Media{}
-			} else {
-				if len(v.Media[0].File.Data) > 0 {
-					me, err := s.m.Save(&v.Media[0].File)
-					if err == nil {
-						me.Name = v.Media[0].Name
-						me.Caption = v.Media[0].Caption
-
-						p.Media = *me
-					}
-				}
-			}
-
-			paragraphs = append(paragraphs, p)
+		// Unknown emails are checked against a dummy hash, so they fail
+		// exactly like wrong passwords.
+		var hash = []byte(a.Hash)
+		if err == ErrAccountNotFound {
+			hash = loginDummyHash()
 		}
 
-		c.Paragraphs = paragraphs
+		if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || err == ErrAccountNotFound {
+			// An unknown email only counts against the address, so guessing
+			// emails does not leave a record behind for each.
+			failed := keys
+			if err == ErrAccountNotFound {
+				failed = keys[:1]
+			}
 
-		err = s.db.PutContent(&c)
-		if err != nil {
-			writeResponse(w, nil, err)
+			s.loginFailed(r, failed, now)
+			s.auditLogin(r, req.Email, ErrLoginFailed)
+			http.Error(w, ErrLoginFailed.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		err = s.db.PutRoute(&Route{
-			Title: c.Title,
-			Slug:  c.Slug,
-		})
-		if err != nil {
-			writeResponse(w, nil, err)
+		// Failures are only forgotten after the second factor, or its codes
+		// could be guessed without limit.
+		if a.TOTP != nil && a.TOTP.Enabled {
+			c, err := s.issueChallenge(a)
+			if err != nil {
+				writeResponse(w, nil, err)
+				return
+			}
+
+			writeResponse(w, c, nil)
 			return
 		}
 
-		writeResponse(w, true, nil)
+		s.loginSucceeded(keys)
+		s.signIn(w, r, a)
 	}
 }
 
-func updateContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// loginVerifyHandler completes a sign in with two-factor authentication,
+// trading the challenge from loginHandler and a TOTP or recovery code for
+// tokens.
+func loginVerifyHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ctx  = context.TODO()
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
-
-		var req UpdateContentRequest
+		var req LoginVerifyRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		c, err := s.db.GetContent(ctx, slug)
+		var (
+			ctx = context.TODO()
+			now = time.Now()
+		)
+
+		id, err := s.parseChallenge(req.Challenge)
 		if err != nil {
-			writeResponse(w, nil, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		c.Title = req.Title
-		c.Subtitle = req.Subtitle
-
-		c.Tags = req.Tags
-		c.Technologies = req.Technologies
-		c.References = req.References
-
-		c.Paragraphs = s.diffParagraphs(c.Paragraphs, req.Paragraphs)
+		a, err := s.db.GetAccount(ctx, id)
+		if err == ErrAccountNotFound {
+			http.Error(w, ErrLoginFailed.Error(), http.StatusUnauthorized)
+			return
+		}
 
-		err = s.db.PutContent(&c)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		writeResponse(w, true, nil)
-	}
-}
+		keys := s.loginKeys(r, a.Email)
+		if s.loginThrottled(w, ctx, keys, now) {
+			return
+		}
+
+		a, err = s.db.UpdateAccount(a.ID, func(a *Account) error {
+			if a.TOTP == nil || !a.TOTP.Enabled || !checkSecondFactor(a.TOTP, req.Code, now) {
+				return ErrInvalidTOTPCode
+			}
+
+			return nil
+		})
 
-func deleteContentHandler(s *Server) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			vars = mux.Vars(r)
-			slug = vars["slug"]
-		)
+		if err == ErrInvalidTOTPCode {
+			s.loginFailed(r, keys, now)
+			s.auditLogin(r, a.Email, ErrInvalidTOTPCode)
+			http.Error(w, ErrLoginFailed.Error(), http.StatusUnauthorized)
+			return
+		}
 
-		err := s.db.DeleteContent(slug)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		writeResponse(w, true, nil)
+		s.loginSucceeded(keys)
+		s.signIn(w, r, a)
 	}
 }
 
-func getMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// forgotPasswordHandler emails a password reset token. It answers the same
+// whether the email belongs to an account or not, and sends in the
+// background so the time taken does not tell either.
+func forgotPasswordHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
-
-		rt, err := s.db.GetRoutes(ctx)
-		if err != nil {
-			writeResponse(w, nil, err)
+		var req ForgotPasswordRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
 			return
 		}
 
-		m, err := s.db.GetMenu(ctx)
-		if err != nil {
-			writeResponse(w, nil, err)
-			return
+		a, err := s.db.GetAccountByEmail(context.TODO(), req.Email)
+		if err == nil {
+			go s.sendPasswordReset(a)
+		} else if err != ErrAccountNotFound {
+			s.l.Error("cannot look up account for password reset", zap.Error(err))
 		}
 
-		var k []int = make([]int, 0, len(m))
-		for v := range m {
-			k = append(k, v)
-		}
-		sort.Ints(k)
+		writeResponse(w, true, nil)
+	}
+}
 
-		var (
-			rt_ = make([]string, 0, len(rt)-len(m))
-			m_  = make([]string, 0, len(m))
-		)
+func (s *Server) sendPasswordReset(a Account) {
+	if r, err := s.db.GetPasswordReset(context.TODO(), a.ID); err == nil && time.Since(r.Created) < PasswordResetInterval {
+		return
+	}
 
-		for _, r := range rt {
-			var found bool
-			for _, v := range m {
-				if v.Slug == r.Slug {
-					found = true
-					break
-				}
-			}
-			if !found {
-				rt_ = append(rt_, r.Slug)
-			}
-		}
-		for _, i := range k {
-			m_ = append(m_, m[i].Slug)
-		}
+	reset, token := NewPasswordReset(a.ID, PasswordResetLifetime)
 
-		req := Menu_{
-			Routes: rt_,
-			Added:  m_,
-		}
+	if err := s.db.PutPasswordReset(&reset); err != nil {
+		s.l.Error("cannot store password reset", zap.String("account", a.ID), zap.Error(err))
+		return
+	}
 
-		writeResponse(w, req, nil)
+	if err := s.ma.Send(a.Email, passwordResetSubject, passwordResetBody(token, s.ao.PasswordResetURL)); err != nil {
+		s.l.Warn("cannot send password reset", zap.String("account", a.ID), zap.Error(err))
 	}
 }
 
-func addToMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// resetPasswordHandler sets a new password with a token from
+// forgotPasswordHandler. Every session of the account is revoked.
+func resetPasswordHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
-
-		var req AddToMenuRequest
+		var req ResetPasswordRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		rt, err := s.db.GetRoutes(ctx)
-		if err != nil {
-			writeResponse(w, nil, err)
+		if req.Password == "" {
+			writeResponse(w, nil, ErrSetupEmpty)
+			return
+		}
+
+		account, hash, ok := parseResetToken(req.Token)
+		if !ok {
+			http.Error(w, ErrInvalidResetToken.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		err := s.db.UsePasswordReset(account, hash, time.Now())
+		if err == ErrInvalidResetToken {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		m, err := s.db.GetMenu(ctx)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		if _, ok := rt[req.Slug]; !ok {
+		a, err := setPassword(context.TODO(), s.db, account, req.Password)
+		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		var keys []int = make([]int, 0, len(m))
-		for k, v := range m {
-			if v.Slug == req.Slug {
-				writeResponse(w, nil, err)
-				return
+		e := newAuditEntry(r, AuditPasswordSet)
+		e.Actor = a.Email
+		e.Target = a.Email
+		e.Status = http.StatusOK
+
+		s.au.Record(e)
+
+		writeResponse(w, true, nil)
+	}
+}
+
+// signIn starts a session for an account that passed every step and
+// answers with its tokens.
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, a Account) {
+	sn := NewSession(a.ID, r)
+
+	t, err := s.issueTokens(sn)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	err = s.db.PutSession(&sn)
+	if err != nil {
+		writeResponse(w, nil, err)
+		return
+	}
+
+	s.pruneSessions(a.ID)
+	s.auditLogin(r, a.Email, nil)
+
+	writeResponse(w, t, nil)
+}
+
+// auditLogin records a sign in, or a failed one with the email that was
+// tried.
+func (s *Server) auditLogin(r *http.Request, email string, err error) {
+	e := newAuditEntry(r, AuditLogin)
+	e.Actor = normalizeEmail(email)
+	e.Target = e.Actor
+	e.Status = http.StatusOK
+
+	if err != nil {
+		e.Status = http.StatusUnauthorized
+		e.Error = err.Error()
+	}
+
+	s.au.Record(e)
+}
+
+// enrollTOTPHandler starts two-factor enrollment of the current account.
+// The secret and recovery codes are only returned here, the factor is
+// enabled once enableTOTPHandler saw a code from it.
+func enrollTOTPHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			a, _          = accountFrom(r.Context())
+			secret        = NewTOTPSecret()
+			codes, hashes = newRecoveryCodes(RecoveryCodeCount)
+		)
+
+		a, err := s.db.UpdateAccount(a.ID, func(a *Account) error {
+			if a.TOTP != nil && a.TOTP.Enabled {
+				return ErrTOTPEnabled
 			}
-			keys = append(keys, k)
-		}
 
-		sort.Ints(keys)
+			a.TOTP = &TOTP{
+				Secret:   secret,
+				Recovery: hashes,
+			}
 
-		m[keys[(len(keys)-1)]+1] = rt[req.Slug]
+			return nil
+		})
 
-		err = s.db.PutMenu(&m)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		writeResponse(w, true, nil)
+		writeResponse(w, TOTPEnrollment_{
+			Secret:        secret,
+			URI:           totpURI(secret, a.Email),
+			RecoveryCodes: codes,
+		}, nil)
 	}
 }
 
-func deleteFromMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+func enableTOTPHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
-
-		var req RemoveFromMenuRequest
+		var req TOTPRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		m, err := s.db.GetMenu(ctx)
-		if err != nil {
-			writeResponse(w, nil, err)
-			return
-		}
-
-		var (
-			i    int   = -1
-			keys []int = make([]int, 0, len(m))
-		)
+		a, _ := accountFrom(r.Context())
 
-		for k, v := range m {
-			if v.Slug == req.Slug {
-				i = k
+		_, err := s.db.UpdateAccount(a.ID, func(a *Account) error {
+			if a.TOTP == nil {
+				return ErrTOTPNotEnrolled
 			}
-			keys = append(keys, k)
-		}
-
-		if i == -1 {
-			writeResponse(w, nil, err)
-			return
-		}
 
-		sort.Ints(keys)
+			if a.TOTP.Enabled {
+				return ErrTOTPEnabled
+			}
 
-		for k := 0; k < len(keys)-1; k++ {
-			if k == i {
-				m[k] = m[k+1]
-				i = k + 1
+			step, ok := totpVerify(a.TOTP.Secret, req.Code, time.Now(), a.TOTP.LastStep)
+			if !ok {
+				return ErrInvalidTOTPCode
 			}
-		}
 
-		delete(m, i)
+			a.TOTP.Enabled = true
+			a.TOTP.LastStep = step
+
+			return nil
+		})
 
-		err = s.db.PutMenu(&m)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
@@ -1700,66 +3184,114 @@ func deleteFromMenuHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
-func siteHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// disableTOTPHandler turns two-factor authentication off, given a current
+// TOTP or recovery code.
+func disableTOTPHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.TODO()
+		var req TOTPRequest
+		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
+			writeResponse(w, nil, e)
+			return
+		}
+
+		a, _ := accountFrom(r.Context())
+
+		_, err := s.db.UpdateAccount(a.ID, func(a *Account) error {
+			if a.TOTP == nil || !a.TOTP.Enabled {
+				return ErrTOTPNotEnrolled
+			}
+
+			if !checkSecondFactor(a.TOTP, req.Code, time.Now()) {
+				return ErrInvalidTOTPCode
+			}
+
+			a.TOTP = nil
+
+			return nil
+		})
 
-		c, err := s.db.GetConfiguration(ctx)
 		if err != nil {
 			writeResponse(w, nil, err)
 			return
 		}
 
-		writeResponse(w, c.Meta.Site, nil)
+		writeResponse(w, true, nil)
 	}
 }
 
-func loginHandler(s *Server) func(http.ResponseWriter, *http.Request) {
+// refreshHandler trades a refresh token for a new access token and the next
+// refresh token. A refresh token that was already traded revokes the whole
+// session, someone else holds a copy of it.
+func refreshHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req LoginRequest
+		var req RefreshRequest
 		if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
 			writeResponse(w, nil, e)
 			return
 		}
 
-		c, err := s.db.GetCredentials(context.TODO())
+		id, g, err := parseRefreshToken([]byte(s.co.GetConfiguration().JwtSecret), req.RefreshToken)
 		if err != nil {
-			writeResponse(w, nil, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		if c.Email != req.Email {
-			http.Error(w, "incorrect email", http.StatusUnauthorized)
+		sn, err := s.db.GetSession(context.TODO(), id)
+		if err == ErrSessionNotFound {
+			http.Error(w, ErrInvalidRefreshToken.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(c.Hash), []byte(req.Password))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			writeResponse(w, nil, err)
 			return
 		}
 
-		u := uuid.New().String()
+		if s.sessionExpired(sn) {
+			s.db.DeleteSession(sn.ID)
+			http.Error(w, ErrSessionExpired.Error(), http.StatusUnauthorized)
+			return
+		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"token": u,
-		})
+		next, err := s.db.RotateSession(sn.ID, g, time.Now())
+		if err == ErrRefreshTokenReused {
+			s.l.Warn("refresh token reused, revoking session", zap.String("account", sn.Account), zap.String("session", sn.ID))
 
-		tokenString, err := token.SignedString([]byte(s.co.GetConfiguration().JwtSecret))
-		if err != nil {
+			if err := s.db.DeleteSession(sn.ID); err != nil && err != ErrSessionNotFound {
+				writeResponse(w, nil, err)
+				return
+			}
+		}
+
+		switch err {
+		case nil:
+		case ErrRefreshTokenReused, ErrInvalidRefreshToken, ErrSessionNotFound:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		default:
 			writeResponse(w, nil, err)
 			return
 		}
 
-		s.ca.Set("token", u)
+		t, err := s.issueTokens(next)
+		if err != nil {
+			writeResponse(w, nil, err)
+			return
+		}
 
-		writeResponse(w, tokenString, nil)
+		writeResponse(w, t, nil)
 	}
 }
 
 func logoutHandler(s *Server) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.ca.Delete("token")
+		if sn, ok := sessionFrom(r.Context()); ok {
+			err := s.db.DeleteSession(sn.ID)
+			if err != nil && err != ErrSessionNotFound {
+				writeResponse(w, nil, err)
+				return
+			}
+		}
 
 		writeResponse(w, true, nil)
 	}
@@ -1788,6 +3320,147 @@ func (s *Server) pushResources(w http.ResponseWriter, c Configuration) {
 	}
 }
 
+// redirect answers requests for renamed projects and pages with a
+// permanent redirect to their current location.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, kind RevisionKind, slug string) bool {
+	rd, err := s.db.GetRedirect(context.TODO(), kind, slug)
+	if err != nil {
+		return false
+	}
+
+	u := redirectPath(kind, rd.To)
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+
+	http.Redirect(w, r, u, http.StatusMovedPermanently)
+
+	return true
+}
+
+// liftDeadlines clears the read and write deadlines of the server for a
+// request moving a whole site, which takes longer than DefaultTimeout.
+func (s *Server) liftDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		s.l.Warn("cannot lift read deadline", zap.Error(err))
+	}
+
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.l.Warn("cannot lift write deadline", zap.Error(err))
+	}
+}
+
+// actor identifies the administrator performing a request.
+func (s *Server) actor(r *http.Request) string {
+	a, _ := accountFrom(r.Context())
+
+	return a.Email
+}
+
+// saveProject writes a project stored under the slug from, renaming it if
+// its slug changed, and records a revision in the same unit of work. An
+// empty from saves a project created by the same request, which has no
+// earlier state worth keeping.
+func (s *Server) saveProject(r *http.Request, from string, p *Project) error {
+	rev, err := NewRevision(RevisionProject, p.Slug, s.actor(r), p)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Atomic(func(tx Tx) error {
+		if from != "" {
+			if err := tx.PreserveRevision(RevisionProject, from); err != nil {
+				return err
+			}
+		}
+
+		if from != "" && p.Slug != from {
+			if err := tx.RenameProject(from, p.Slug); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.PutProject(p); err != nil {
+			return err
+		}
+
+		return tx.PutRevision(&rev)
+	})
+}
+
+// saveContent writes a page like saveProject does, together with its
+// route when one is given.
+func (s *Server) saveContent(r *http.Request, from string, c *Content, route *Route) error {
+	rev, err := NewRevision(RevisionContent, c.Slug, s.actor(r), c)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Atomic(func(tx Tx) error {
+		if from != "" {
+			if err := tx.PreserveRevision(RevisionContent, from); err != nil {
+				return err
+			}
+		}
+
+		if from != "" && c.Slug != from {
+			if err := tx.RenameContent(from, c.Slug); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.PutContent(c); err != nil {
+			return err
+		}
+
+		if route != nil {
+			if err := tx.PutRoute(route); err != nil {
+				return err
+			}
+		}
+
+		return tx.PutRevision(&rev)
+	})
+}
+
+// currentRevision wraps the stored state of an item as an unsaved
+// revision, so it can be compared with the history.
+func (s *Server) currentRevision(kind RevisionKind, slug string) (Revision, error) {
+	var (
+		ctx = context.TODO()
+		v   interface{}
+		err error
+	)
+
+	switch kind {
+	case RevisionProject:
+		v, err = s.db.GetProject(ctx, slug)
+	case RevisionContent:
+		v, err = s.db.GetContent(ctx, slug)
+	}
+
+	if err != nil {
+		return Revision{}, err
+	}
+
+	return NewRevision(kind, slug, "", v)
+}
+
+func (s *Server) reconfigure(c Configuration) error {
+	c0, c1 := s.co.Configure(c)
+
+	select {
+	case <-c0:
+		return nil
+	case e := <-c1:
+		return e
+	case <-time.After(ConfigurationTimeoutInterval):
+		return ErrConfigurationTimedOut
+	}
+}
+
 func (s *Server) provideSigningFunc() func(token *jwt.Token) (interface{}, error) {
 	return func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -1868,7 +3541,6 @@ func (s *Server) diffParagraphs(ps []Paragraph, pps []Paragraph_) []Paragraph {
 			p.Content = req.Content
 
 			if len(req.Media[0].File.Data) > 0 {
-				s.m.Delete(&p.Media)
 				me, err := s.m.Save(&req.Media[0].File)
 				if err == nil {
 					p.Media = *me
@@ -1879,8 +3551,6 @@ func (s *Server) diffParagraphs(ps []Paragraph, pps []Paragraph_) []Paragraph {
 			p.Media.Caption = req.Media[0].Caption
 
 			oldParagraphs = append(oldParagraphs, p)
-		} else {
-			s.m.Delete(&p.Media)
 		}
 	}
 	for _, pp := range pps {
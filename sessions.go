@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/uuid"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_SESSIONS string = "sessions"
+
+	// SessionTouchInterval limits how often the last-seen time of a session
+	// is written, so not every admin request turns into a write.
+	SessionTouchInterval time.Duration = time.Minute
+)
+
+func NewSession(account string, r *http.Request) Session {
+	now := time.Now()
+
+	return Session{
+		ID:        uuid.New().String(),
+		Account:   account,
+		Created:   now,
+		LastSeen:  now,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func withSession(ctx context.Context, s Session) context.Context {
+	return context.WithValue(ctx, sessionContextKey, s)
+}
+
+// sessionFrom returns the session a request was made in.
+func sessionFrom(ctx context.Context) (Session, bool) {
+	s, ok := ctx.Value(sessionContextKey).(Session)
+	return s, ok
+}
+
+// Bolt
+func (db *cachedDatabase) GetSessions(ctx context.Context, account string) ([]Session, error) {
+	ss := make([]Session, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var s Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+
+			if s.Account == account {
+				ss = append(ss, s)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get sessions", zap.Error(err))
+		return ss, ErrDatabase
+	}
+
+	return ss, nil
+}
+
+func (db *cachedDatabase) GetSession(ctx context.Context, id string) (Session, error) {
+	var s Session
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+		if b == nil {
+			return ErrSessionNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSessionNotFound
+		}
+
+		return json.Unmarshal(v, &s)
+	})
+
+	if err != nil && err != ErrSessionNotFound {
+		db.logger.Error("cannot get session", zap.Error(err))
+		return s, ErrDatabase
+	}
+
+	return s, err
+}
+
+func (db *cachedDatabase) PutSession(session *Session) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BUCKET_SESSIONS))
+		if err != nil {
+			return err
+		}
+
+		return save(b, []byte(session.ID), session)
+	})
+}
+
+// TouchSession updates the last-seen time of a session that still exists,
+// so a request racing a revocation cannot bring the session back.
+func (db *cachedDatabase) TouchSession(id string, t time.Time) error {
+	_, err := db.updateSession(id, func(s *Session) error {
+		s.LastSeen = t
+		return nil
+	})
+
+	return err
+}
+
+// RotateSession advances a session to its next refresh token generation,
+// see rotateSession.
+func (db *cachedDatabase) RotateSession(id string, generation uint64, t time.Time) (Session, error) {
+	return db.updateSession(id, func(s *Session) error {
+		return rotateSession(s, generation, t)
+	})
+}
+
+func (db *cachedDatabase) updateSession(id string, fn func(s *Session) error) (Session, error) {
+	var s Session
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+		if b == nil {
+			return ErrSessionNotFound
+		}
+
+		v := b.Get([]byte(id))
+		if v == nil {
+			return ErrSessionNotFound
+		}
+
+		if err := json.Unmarshal(v, &s); err != nil {
+			return err
+		}
+
+		if err := fn(&s); err != nil {
+			return err
+		}
+
+		return save(b, []byte(id), s)
+	})
+
+	return s, err
+}
+
+func (db *cachedDatabase) DeleteSession(id string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+		if b == nil || b.Get([]byte(id)) == nil {
+			return ErrSessionNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// DeleteSessions revokes every session of an account except the one given,
+// which may be empty.
+func (db *cachedDatabase) DeleteSessions(account string, except string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_SESSIONS))
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var s Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+
+			if s.Account == account && s.ID != except {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SQLite
+func (db *sqliteDatabase) GetSessions(ctx context.Context, account string) ([]Session, error) {
+	ss := make([]Session, 0)
+	err := db.queryRecords(ctx, `SELECT data FROM sessions WHERE account = ?`, func(data []byte) error {
+		var s Session
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+
+		ss = append(ss, s)
+		return nil
+	}, account)
+
+	if err != nil {
+		db.logger.Error("cannot get sessions", zap.Error(err))
+		return ss, ErrDatabase
+	}
+
+	return ss, nil
+}
+
+func (db *sqliteDatabase) GetSession(ctx context.Context, id string) (Session, error) {
+	var s Session
+	err := db.getRecord(ctx, `SELECT data FROM sessions WHERE id = ?`, id, &s)
+	if err == sql.ErrNoRows {
+		return s, ErrSessionNotFound
+	}
+
+	if err != nil {
+		db.logger.Error("cannot get session", zap.Error(err))
+		return s, ErrDatabase
+	}
+
+	return s, nil
+}
+
+func (db *sqliteDatabase) PutSession(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO sessions (id, account, data) VALUES (?, ?, ?)`, session.ID, session.Account, string(data))
+		return err
+	})
+}
+
+func (db *sqliteDatabase) TouchSession(id string, t time.Time) error {
+	_, err := db.updateSession(id, func(s *Session) error {
+		s.LastSeen = t
+		return nil
+	})
+
+	return err
+}
+
+func (db *sqliteDatabase) RotateSession(id string, generation uint64, t time.Time) (Session, error) {
+	return db.updateSession(id, func(s *Session) error {
+		return rotateSession(s, generation, t)
+	})
+}
+
+func (db *sqliteDatabase) updateSession(id string, fn func(s *Session) error) (Session, error) {
+	var s Session
+	err := db.update(func(tx *sql.Tx) error {
+		var data string
+
+		err := tx.QueryRow(`SELECT data FROM sessions WHERE id = ?`, id).Scan(&data)
+		if err == sql.ErrNoRows {
+			return ErrSessionNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			return err
+		}
+
+		if err := fn(&s); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE sessions SET data = ? WHERE id = ?`, string(b), id)
+		return err
+	})
+
+	return s, err
+}
+
+func (db *sqliteDatabase) DeleteSession(id string) error {
+	return db.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err == nil {
+				err = ErrSessionNotFound
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (db *sqliteDatabase) DeleteSessions(account string, except string) error {
+	return db.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM sessions WHERE account = ? AND id <> ?`, account, except)
+		return err
+	})
+}
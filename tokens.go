@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"go.uber.org/zap"
+)
+
+const (
+	AccessTokenLifetime  time.Duration = 15 * time.Minute
+	RefreshTokenLifetime time.Duration = 30 * 24 * time.Hour
+)
+
+type AuthOptions struct {
+	// AccessTokenLifetime is how long a signed access token is accepted.
+	AccessTokenLifetime time.Duration
+
+	// RefreshTokenLifetime is how long a session may stay unused before
+	// its refresh token is no longer accepted.
+	RefreshTokenLifetime time.Duration
+
+	Login LoginPolicy
+
+	// PasswordResetURL is the page password reset emails link to, with the
+	// token appended. Without it the email only holds the token.
+	PasswordResetURL string
+}
+
+// issueTokens signs an access token for a session and returns it together
+// with the refresh token of the session's current generation.
+func (s *Server) issueTokens(sn Session) (Token_, error) {
+	var (
+		now    = time.Now()
+		secret = []byte(s.co.GetConfiguration().JwtSecret)
+		d      = s.ao.AccessTokenLifetime
+	)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     sn.Account,
+		"session": sn.ID,
+		"iat":     now.Unix(),
+		"exp":     now.Add(d).Unix(),
+	})
+
+	access, err := token.SignedString(secret)
+	if err != nil {
+		return Token_{}, err
+	}
+
+	return Token_{
+		AccessToken:  access,
+		RefreshToken: refreshToken(secret, sn.ID, sn.Generation),
+		ExpiresIn:    int64(d / time.Second),
+	}, nil
+}
+
+// sessionExpired reports whether a session went unused for longer than a
+// refresh token lasts. A lifetime of 0 keeps sessions until revoked.
+func (s *Server) sessionExpired(sn Session) bool {
+	return s.ao.RefreshTokenLifetime > 0 && time.Since(sn.LastSeen) > s.ao.RefreshTokenLifetime
+}
+
+// pruneSessions drops the expired sessions of an account.
+func (s *Server) pruneSessions(account string) {
+	ss, err := s.db.GetSessions(context.TODO(), account)
+	if err != nil {
+		return
+	}
+
+	for _, v := range ss {
+		if s.sessionExpired(v) {
+			if err := s.db.DeleteSession(v.ID); err != nil && err != ErrSessionNotFound {
+				s.l.Warn("cannot prune session", zap.String("session", v.ID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// refreshToken derives the refresh token of a session generation. Tokens
+// are not stored, any generation can be verified again, so a token that
+// was already rotated is recognised when it comes back.
+func refreshToken(secret []byte, session string, generation uint64) string {
+	g := strconv.FormatUint(generation, 10)
+
+	return session + "." + g + "." + refreshMAC(secret, session, g)
+}
+
+func refreshMAC(secret []byte, session string, generation string) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte("refresh." + session + "." + generation))
+
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// parseRefreshToken returns the session and generation of a refresh token
+// signed with secret.
+func parseRefreshToken(secret []byte, token string) (string, uint64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(refreshMAC(secret, parts[0], parts[1]))) {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	g, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidRefreshToken
+	}
+
+	return parts[0], g, nil
+}
+
+// rotateSession moves a session on to its next generation if the refresh
+// token presented is the current one.
+func rotateSession(s *Session, generation uint64, t time.Time) error {
+	if generation < s.Generation {
+		return ErrRefreshTokenReused
+	}
+
+	if generation > s.Generation {
+		return ErrInvalidRefreshToken
+	}
+
+	s.Generation++
+	s.LastSeen = t
+
+	return nil
+}
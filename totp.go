@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	TOTPIssuer string        = "Showcase"
+	TOTPDigits int           = 6
+	TOTPPeriod time.Duration = 30 * time.Second
+
+	// TOTPSkew is the number of periods a code may be early or late, to
+	// allow for clocks that drift apart.
+	TOTPSkew int64 = 1
+
+	RecoveryCodeCount int = 10
+
+	MFAChallengeLifetime time.Duration = 5 * time.Minute
+
+	mfaPurpose string = "mfa"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func NewTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return totpEncoding.EncodeToString(b)
+}
+
+// totpURI is the provisioning URI authenticator apps read from a QR code.
+func totpURI(secret string, email string) string {
+	var v = url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", TOTPIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTPDigits))
+	v.Set("period", fmt.Sprint(int64(TOTPPeriod/time.Second)))
+
+	return "otpauth://totp/" + url.PathEscape(TOTPIssuer+":"+email) + "?" + v.Encode()
+}
+
+// totpCode computes the HOTP value (RFC 4226) of a time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	m := hmac.New(sha1.New, key)
+	m.Write(msg[:])
+	sum := m.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	var mod uint32 = 1
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// totpVerify checks a code against the steps around now and returns the
+// step it matched. Steps up to last were already used and are refused, so
+// a code cannot be replayed.
+func totpVerify(secret string, code string, now time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / int64(TOTPPeriod/time.Second)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if step <= last {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// newRecoveryCodes returns codes to hand out once and the hashes to keep.
+func newRecoveryCodes(n int) ([]string, []string) {
+	var (
+		codes  = make([]string, 0, n)
+		hashes = make([]string, 0, n)
+	)
+
+	for i := 0; i < n; i++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+
+		c := hex.EncodeToString(b)
+		c = c[:4] + "-" + c[4:8] + "-" + c[8:12] + "-" + c[12:]
+
+		codes = append(codes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+
+	return codes, hashes
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecoveryCode consumes a recovery code.
+func useRecoveryCode(t *TOTP, code string) bool {
+	h := hashRecoveryCode(code)
+	for i, v := range t.Recovery {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(h)) == 1 {
+			t.Recovery = append(t.Recovery[:i], t.Recovery[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSecondFactor accepts a current TOTP code, or consumes a recovery
+// code.
+func checkSecondFactor(t *TOTP, code string, now time.Time) bool {
+	if step, ok := totpVerify(t.Secret, code, now, t.LastStep); ok {
+		t.LastStep = step
+		return true
+	}
+
+	return useRecoveryCode(t, code)
+}
+
+// issueChallenge signs the proof that an account passed the password step,
+// which is traded for tokens together with a second factor code.
+func (s *Server) issueChallenge(a Account) (Challenge_, error) {
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     a.ID,
+		"purpose": mfaPurpose,
+		"iat":     now.Unix(),
+		"exp":     now.Add(MFAChallengeLifetime).Unix(),
+	})
+
+	challenge, err := token.SignedString([]byte(s.co.GetConfiguration().JwtSecret))
+	if err != nil {
+		return Challenge_{}, err
+	}
+
+	return Challenge_{
+		Challenge: challenge,
+		ExpiresIn: int64(MFAChallengeLifetime / time.Second),
+	}, nil
+}
+
+// parseChallenge returns the account a challenge was issued to.
+func (s *Server) parseChallenge(challenge string) (string, error) {
+	token, err := jwt.Parse(challenge, s.provideSigningFunc())
+	if err != nil {
+		return "", ErrLoginFailed
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || !claims.VerifyExpiresAt(time.Now().Unix(), true) || claims["purpose"] != mfaPurpose {
+		return "", ErrLoginFailed
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", ErrLoginFailed
+	}
+
+	return sub, nil
+}
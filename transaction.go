@@ -0,0 +1,503 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// Tx is a unit of work. Everything written through it is committed
+// together when the function given to DB.Atomic returns nil, and rolled
+// back otherwise. Caches are only touched after a successful commit.
+//
+// The function must not call back into the DB, reads belong inside the
+// unit of work as well.
+type Tx interface {
+	GetMenu() (Menu, error)
+	GetProjects() ([]Project, error)
+	GetContents() ([]Content, error)
+	GetRoutes() (map[string]Route, error)
+
+	CreateContent(content *Content) error
+	CreateProject(project *Project) error
+
+	PutUser(user *User) error
+	PutConfiguration(configuration *Configuration) error
+	PutContent(content *Content) error
+	PutProject(project *Project) error
+	PutRoute(route *Route) error
+	PutMenu(menu *Menu) error
+	PutRevision(revision *Revision) error
+	PreserveRevision(kind RevisionKind, slug string) error
+
+	ReplaceAccounts(accounts []Account) error
+
+	DeleteContent(slug string) error
+	DeleteProject(slug string) error
+	DeleteRoute(slug string) error
+
+	RenameContent(from string, to string) error
+	RenameProject(from string, to string) error
+}
+
+type cacheOp struct {
+	key    string
+	value  interface{}
+	delete bool
+}
+
+// cacheOps collects the cache changes of a unit of work, so they can be
+// applied once it has been committed.
+type cacheOps []cacheOp
+
+func (c *cacheOps) set(key string, value interface{}) {
+	*c = append(*c, cacheOp{key: key, value: value})
+}
+
+func (c *cacheOps) delete(keys ...string) {
+	for _, k := range keys {
+		*c = append(*c, cacheOp{key: k, delete: true})
+	}
+}
+
+func (c cacheOps) apply(cache Cache) {
+	for _, op := range c {
+		if op.delete {
+			cache.Delete(op.key)
+		} else {
+			cache.Set(op.key, op.value)
+		}
+	}
+}
+
+// Bolt
+type boltTx struct {
+	tx      *bolt.Tx
+	options DatabaseOptions
+	ops     cacheOps
+}
+
+func (db *cachedDatabase) Atomic(fn func(tx Tx) error) error {
+	var t *boltTx
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		t = &boltTx{
+			tx:      tx,
+			options: db.options,
+		}
+
+		return fn(t)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.ops.apply(db.cache)
+
+	return nil
+}
+
+func (t *boltTx) GetMenu() (Menu, error) {
+	return readMenu(t.tx)
+}
+
+func (t *boltTx) GetProjects() ([]Project, error) {
+	var ps []Project
+	err := t.tx.Bucket([]byte(BUCKET_PROJECTS)).ForEach(func(k, v []byte) error {
+		var p Project
+		if err := json.Unmarshal(v, &p); err != nil {
+			return err
+		}
+
+		ps = append(ps, p)
+		return nil
+	})
+
+	return ps, err
+}
+
+func (t *boltTx) GetContents() ([]Content, error) {
+	var cs []Content
+	err := t.tx.Bucket([]byte(BUCKET_CONTENT)).ForEach(func(k, v []byte) error {
+		var c Content
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+
+		cs = append(cs, c)
+		return nil
+	})
+
+	return cs, err
+}
+
+func (t *boltTx) GetRoutes() (map[string]Route, error) {
+	var r = make(map[string]Route)
+	err := t.tx.Bucket([]byte(BUCKET_ROUTES)).ForEach(func(k, v []byte) error {
+		var o Route
+		if err := json.Unmarshal(v, &o); err != nil {
+			return err
+		}
+
+		r[o.Slug] = o
+		return nil
+	})
+
+	return r, err
+}
+
+func (t *boltTx) CreateContent(content *Content) error {
+	if t.tx.Bucket([]byte(BUCKET_CONTENT)).Get([]byte(content.Slug)) != nil {
+		return ErrContentExists
+	}
+
+	return t.PutContent(content)
+}
+
+func (t *boltTx) CreateProject(project *Project) error {
+	if t.tx.Bucket([]byte(BUCKET_PROJECTS)).Get([]byte(project.Slug)) != nil {
+		return ErrProjectExists
+	}
+
+	return t.PutProject(project)
+}
+
+func (t *boltTx) PutUser(user *User) error {
+	err := save(t.tx.Bucket([]byte(BUCKET_COMMON)), []byte("user"), user)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("user", *user)
+
+	return nil
+}
+
+func (t *boltTx) PutConfiguration(configuration *Configuration) error {
+	err := save(t.tx.Bucket([]byte(BUCKET_COMMON)), []byte("configuration"), configuration)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("configuration", *configuration)
+
+	return nil
+}
+
+func (t *boltTx) PutContent(content *Content) error {
+	if content.Slug == "" {
+		return ErrNoSlug
+	}
+
+	err := save(t.tx.Bucket([]byte(BUCKET_CONTENT)), []byte(content.Slug), content)
+	if err != nil {
+		return err
+	}
+
+	if err = t.retitleMenuEntry(content.Slug, content.Title); err != nil {
+		return err
+	}
+
+	t.ops.delete("routes", "contents")
+	t.ops.set(fmt.Sprintf("content-%s", content.Slug), *content)
+
+	return nil
+}
+
+func (t *boltTx) PutProject(project *Project) error {
+	if project.Slug == "" {
+		return ErrNoSlug
+	}
+
+	err := save(t.tx.Bucket([]byte(BUCKET_PROJECTS)), []byte(project.Slug), project)
+	if err != nil {
+		return err
+	}
+
+	if err = t.retitleMenuEntry(project.Slug, project.Title); err != nil {
+		return err
+	}
+
+	t.ops.delete("routes", "projects")
+	t.ops.set(fmt.Sprintf("project-%s", project.Slug), *project)
+
+	return nil
+}
+
+func (t *boltTx) PutRoute(route *Route) error {
+	err := save(t.tx.Bucket([]byte(BUCKET_ROUTES)), []byte(route.Slug), route)
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("routes")
+
+	return nil
+}
+
+func (t *boltTx) PutMenu(menu *Menu) error {
+	err := save(t.tx.Bucket([]byte(BUCKET_COMMON)), []byte("menu"), menu)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("menu", *menu)
+
+	return nil
+}
+
+func (t *boltTx) PutRevision(revision *Revision) error {
+	return putRevision(t.tx, revision, t.options.HistoryLimit)
+}
+
+// retitleMenuEntry keeps the menu entry of an item in step with its title.
+func (t *boltTx) retitleMenuEntry(slug string, title string) error {
+	m, err := readMenu(t.tx)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range m {
+		if r.Slug == slug {
+			m[i] = Route{Title: title, Slug: slug}
+			return t.PutMenu(&m)
+		}
+	}
+
+	return nil
+}
+
+func readMenu(tx *bolt.Tx) (Menu, error) {
+	var m Menu = make(Menu)
+
+	v := tx.Bucket([]byte(BUCKET_COMMON)).Get([]byte("menu"))
+	if v == nil {
+		return m, nil
+	}
+
+	err := json.Unmarshal(v, &m)
+
+	return m, err
+}
+
+// SQLite
+type sqliteTx struct {
+	tx      *sql.Tx
+	options DatabaseOptions
+	ops     cacheOps
+}
+
+func (db *sqliteDatabase) Atomic(fn func(tx Tx) error) error {
+	var t *sqliteTx
+	err := db.update(func(tx *sql.Tx) error {
+		t = &sqliteTx{
+			tx:      tx,
+			options: db.options,
+		}
+
+		return fn(t)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	t.ops.apply(db.cache)
+
+	return nil
+}
+
+func (t *sqliteTx) GetMenu() (Menu, error) {
+	return readSqlMenu(t.tx)
+}
+
+func (t *sqliteTx) GetProjects() ([]Project, error) {
+	var ps []Project
+	err := t.queryRecords(`SELECT data FROM projects ORDER BY slug`, func(data []byte) error {
+		var p Project
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+
+		ps = append(ps, p)
+		return nil
+	})
+
+	return ps, err
+}
+
+func (t *sqliteTx) GetContents() ([]Content, error) {
+	var cs []Content
+	err := t.queryRecords(`SELECT data FROM contents ORDER BY slug`, func(data []byte) error {
+		var c Content
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+
+		cs = append(cs, c)
+		return nil
+	})
+
+	return cs, err
+}
+
+func (t *sqliteTx) GetRoutes() (map[string]Route, error) {
+	var r = make(map[string]Route)
+
+	rows, err := t.tx.Query(`SELECT slug, title FROM routes ORDER BY slug`)
+	if err != nil {
+		return r, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o Route
+		if err := rows.Scan(&o.Slug, &o.Title); err != nil {
+			return r, err
+		}
+		r[o.Slug] = o
+	}
+
+	return r, rows.Err()
+}
+
+func (t *sqliteTx) queryRecords(query string, fn func(data []byte) error) error {
+	rows, err := t.tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (t *sqliteTx) CreateContent(content *Content) error {
+	var n int
+	err := t.tx.QueryRow(`SELECT COUNT(*) FROM contents WHERE slug = ?`, content.Slug).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return ErrContentExists
+	}
+
+	return t.PutContent(content)
+}
+
+func (t *sqliteTx) CreateProject(project *Project) error {
+	var n int
+	err := t.tx.QueryRow(`SELECT COUNT(*) FROM projects WHERE slug = ?`, project.Slug).Scan(&n)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return ErrProjectExists
+	}
+
+	return t.PutProject(project)
+}
+
+func (t *sqliteTx) PutUser(user *User) error {
+	err := putCommon(t.tx, "user", user)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("user", *user)
+
+	return nil
+}
+
+func (t *sqliteTx) PutConfiguration(configuration *Configuration) error {
+	err := putCommon(t.tx, "configuration", configuration)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("configuration", *configuration)
+
+	return nil
+}
+
+func (t *sqliteTx) PutContent(content *Content) error {
+	if content.Slug == "" {
+		return ErrNoSlug
+	}
+
+	err := putRecord(t.tx, "contents", content.Slug, content.Title, content.Subtitle, content.Published, content)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tx.Exec(`UPDATE menu SET title = ? WHERE slug = ?`, content.Title, content.Slug)
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("menu", "routes", "contents")
+	t.ops.set(fmt.Sprintf("content-%s", content.Slug), *content)
+
+	return nil
+}
+
+func (t *sqliteTx) PutProject(project *Project) error {
+	if project.Slug == "" {
+		return ErrNoSlug
+	}
+
+	err := putRecord(t.tx, "projects", project.Slug, project.Title, project.Subtitle, project.Published, project)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.tx.Exec(`UPDATE menu SET title = ? WHERE slug = ?`, project.Title, project.Slug)
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("menu", "routes", "projects")
+	t.ops.set(fmt.Sprintf("project-%s", project.Slug), *project)
+
+	return nil
+}
+
+func (t *sqliteTx) PutRoute(route *Route) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO routes (slug, title) VALUES (?, ?)`, route.Slug, route.Title)
+	if err != nil {
+		return err
+	}
+
+	t.ops.delete("routes")
+
+	return nil
+}
+
+func (t *sqliteTx) PutMenu(menu *Menu) error {
+	err := putMenu(t.tx, *menu)
+	if err != nil {
+		return err
+	}
+
+	t.ops.set("menu", *menu)
+
+	return nil
+}
+
+func (t *sqliteTx) PutRevision(revision *Revision) error {
+	return putSqlRevision(t.tx, revision, t.options.HistoryLimit)
+}
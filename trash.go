@@ -0,0 +1,753 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BUCKET_TRASH string = "trash"
+
+	TrashRetention     time.Duration = time.Hour * 24 * 30
+	TrashPurgeInterval time.Duration = time.Hour
+)
+
+// NewTrashItem wraps a stored project or page, given as its raw JSON, so
+// it can be moved into the trash.
+func NewTrashItem(kind RevisionKind, data []byte) (TrashItem, error) {
+	item := TrashItem{
+		Kind:    kind,
+		Deleted: time.Now(),
+		Data:    data,
+	}
+
+	var media []*Media
+	switch kind {
+	case RevisionProject:
+		var p Project
+		if err := json.Unmarshal(data, &p); err != nil {
+			return item, err
+		}
+
+		item.Slug, item.Title = p.Slug, p.Title
+		media = projectMedia(&p)
+	case RevisionContent:
+		var c Content
+		if err := json.Unmarshal(data, &c); err != nil {
+			return item, err
+		}
+
+		item.Slug, item.Title = c.Slug, c.Title
+		media = contentMedia(&c)
+	}
+
+	for _, m := range media {
+		if m.Path != "" {
+			item.Media = append(item.Media, m.Path)
+		}
+	}
+
+	return item, nil
+}
+
+// removeMenuEntry removes a slug from the menu and closes the gap. It
+// returns the position the entry had.
+func removeMenuEntry(m Menu, slug string) (int, bool) {
+	keys := menuKeys(m)
+
+	for i, k := range keys {
+		if m[k].Slug != slug {
+			continue
+		}
+
+		for j := i; j < len(keys)-1; j++ {
+			m[keys[j]] = m[keys[j+1]]
+		}
+		delete(m, keys[len(keys)-1])
+
+		return k, true
+	}
+
+	return 0, false
+}
+
+// insertMenuEntry puts a route back at a position, moving the following
+// entries down. Positions past the end append to the menu.
+func insertMenuEntry(m Menu, position int, r Route) {
+	keys := menuKeys(m)
+
+	if len(keys) == 0 || position > keys[len(keys)-1] {
+		if len(keys) > 0 {
+			position = keys[len(keys)-1] + 1
+		}
+
+		m[position] = r
+		return
+	}
+
+	for i := len(keys) - 1; i >= 0 && keys[i] >= position; i-- {
+		m[keys[i]+1] = m[keys[i]]
+	}
+
+	m[position] = r
+}
+
+func menuKeys(m Menu) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	return keys
+}
+
+func notFound(kind RevisionKind) error {
+	if kind == RevisionProject {
+		return ErrProjectNotFound
+	}
+
+	return ErrContentNotFound
+}
+
+func exists(kind RevisionKind) error {
+	if kind == RevisionProject {
+		return ErrProjectExists
+	}
+
+	return ErrContentExists
+}
+
+// itemCacheKeys lists every cache entry that may hold a project or page,
+// including the routes and menu it can appear in.
+func itemCacheKeys(kind RevisionKind, slug string) []string {
+	if kind == RevisionProject {
+		return []string{fmt.Sprintf("project-%s", slug), "projects", "routes", "menu"}
+	}
+
+	return []string{fmt.Sprintf("content-%s", slug), "contents", "routes", "menu"}
+}
+
+// TrashCollector permanently removes trashed items together with their
+// media, either on request or once they are older than the retention.
+type TrashCollector struct {
+	db        DB
+	mm        *MediaManager
+	logger    *zap.Logger
+	retention time.Duration
+	stop      chan bool
+}
+
+func NewTrashCollector(db DB, mm *MediaManager, logger *zap.Logger, retention time.Duration) *TrashCollector {
+	return &TrashCollector{
+		db:        db,
+		mm:        mm,
+		logger:    logger,
+		retention: retention,
+		stop:      make(chan bool),
+	}
+}
+
+func (t *TrashCollector) Run() {
+	t.Expire()
+
+	ticker := time.NewTicker(TrashPurgeInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				t.Expire()
+			case <-t.stop:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Expire purges every item that has been in the trash for longer than
+// the retention.
+func (t *TrashCollector) Expire() {
+	var ctx = context.TODO()
+
+	items, err := t.db.GetTrash(ctx)
+	if err != nil {
+		t.logger.Error("cannot get trash to purge", zap.Error(err))
+		return
+	}
+
+	for _, v := range items {
+		if time.Since(v.Deleted) < t.retention {
+			continue
+		}
+
+		if err := t.Purge(ctx, v.ID); err != nil {
+			t.logger.Error("cannot purge trash item", zap.Uint64("id", v.ID), zap.Error(err))
+		}
+	}
+}
+
+// Purge removes an item from the trash for good, along with its history.
+// Its media files are deleted unless a record, another trashed item or a
+// stored revision still references them.
+func (t *TrashCollector) Purge(ctx context.Context, id uint64) error {
+	item, err := t.db.PurgeTrashItem(id)
+	if err != nil {
+		return err
+	}
+
+	used, err := referencedMedia(ctx, t.db)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range item.Media {
+		if used[p] {
+			continue
+		}
+
+		err := t.mm.Delete(&Media{Path: p})
+		if err != nil && !os.IsNotExist(err) {
+			t.logger.Warn("cannot delete trashed media", zap.String("path", p), zap.Error(err))
+		}
+	}
+
+	t.logger.Info("trash purged",
+		zap.String("kind", string(item.Kind)),
+		zap.String("slug", item.Slug),
+		zap.Int("media", len(item.Media)))
+
+	return nil
+}
+
+func (t *TrashCollector) Finalize() {
+	t.stop <- true
+}
+
+// Bolt
+func (db *cachedDatabase) trash(kind RevisionKind, bucket string, slug string) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		return trashItem(tx, kind, bucket, slug)
+	})
+}
+
+// trashItem moves an item into the trash, together with its route and
+// menu entry.
+func trashItem(tx *bolt.Tx, kind RevisionKind, bucket string, slug string) error {
+	b := tx.Bucket([]byte(bucket))
+
+	v := b.Get([]byte(slug))
+	if v == nil {
+		return notFound(kind)
+	}
+
+	item, err := NewTrashItem(kind, append([]byte(nil), v...))
+	if err != nil {
+		return err
+	}
+
+	rb := tx.Bucket([]byte(BUCKET_ROUTES))
+	if r := rb.Get([]byte(slug)); r != nil {
+		var route Route
+		if err := json.Unmarshal(r, &route); err != nil {
+			return err
+		}
+
+		item.Route = &route
+
+		if err := rb.Delete([]byte(slug)); err != nil {
+			return err
+		}
+	}
+
+	cb := tx.Bucket([]byte(BUCKET_COMMON))
+
+	m, err := readMenu(tx)
+	if err != nil {
+		return err
+	}
+
+	if item.MenuPosition, item.InMenu = removeMenuEntry(m, slug); item.InMenu {
+		if err := save(cb, []byte("menu"), m); err != nil {
+			return err
+		}
+	}
+
+	tb, err := tx.CreateBucketIfNotExists([]byte(BUCKET_TRASH))
+	if err != nil {
+		return err
+	}
+
+	if item.ID, err = tb.NextSequence(); err != nil {
+		return err
+	}
+
+	if err := save(tb, itob(item.ID), item); err != nil {
+		return err
+	}
+
+	return b.Delete([]byte(slug))
+}
+
+func (t *boltTx) DeleteProject(slug string) error {
+	if err := trashItem(t.tx, RevisionProject, BUCKET_PROJECTS, slug); err != nil {
+		return err
+	}
+
+	t.ops.delete(itemCacheKeys(RevisionProject, slug)...)
+
+	return nil
+}
+
+func (t *boltTx) DeleteContent(slug string) error {
+	if err := trashItem(t.tx, RevisionContent, BUCKET_CONTENT, slug); err != nil {
+		return err
+	}
+
+	t.ops.delete(itemCacheKeys(RevisionContent, slug)...)
+
+	return nil
+}
+
+func (db *cachedDatabase) GetTrash(ctx context.Context) ([]TrashItem, error) {
+	items := make([]TrashItem, 0)
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BUCKET_TRASH))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var item TrashItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get trash", zap.Error(err))
+		return items, ErrDatabase
+	}
+
+	return items, nil
+}
+
+func (db *cachedDatabase) RestoreTrashItem(id uint64) (TrashItem, error) {
+	var item TrashItem
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		tb := tx.Bucket([]byte(BUCKET_TRASH))
+		if tb == nil {
+			return ErrTrashItemNotFound
+		}
+
+		v := tb.Get(itob(id))
+		if v == nil {
+			return ErrTrashItemNotFound
+		}
+
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+
+		bucket := BUCKET_PROJECTS
+		if item.Kind == RevisionContent {
+			bucket = BUCKET_CONTENT
+		}
+
+		b := tx.Bucket([]byte(bucket))
+		if b.Get([]byte(item.Slug)) != nil {
+			return exists(item.Kind)
+		}
+
+		if err := b.Put([]byte(item.Slug), item.Data); err != nil {
+			return err
+		}
+
+		if item.Route != nil {
+			if err := save(tx.Bucket([]byte(BUCKET_ROUTES)), []byte(item.Slug), item.Route); err != nil {
+				return err
+			}
+		}
+
+		if item.InMenu && item.Route != nil {
+			cb := tx.Bucket([]byte(BUCKET_COMMON))
+
+			m, err := readMenu(tx)
+			if err != nil {
+				return err
+			}
+
+			insertMenuEntry(m, item.MenuPosition, *item.Route)
+
+			if err := save(cb, []byte("menu"), m); err != nil {
+				return err
+			}
+		}
+
+		return tb.Delete(itob(id))
+	})
+
+	if err != nil {
+		return item, err
+	}
+
+	db.invalidateItem(item.Kind, item.Slug)
+
+	return item, nil
+}
+
+func (db *cachedDatabase) PurgeTrashItem(id uint64) (TrashItem, error) {
+	var item TrashItem
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		tb := tx.Bucket([]byte(BUCKET_TRASH))
+		if tb == nil {
+			return ErrTrashItemNotFound
+		}
+
+		v := tb.Get(itob(id))
+		if v == nil {
+			return ErrTrashItemNotFound
+		}
+
+		if err := json.Unmarshal(v, &item); err != nil {
+			return err
+		}
+
+		if err := tb.Delete(itob(id)); err != nil {
+			return err
+		}
+
+		return purgeHistory(tx, item)
+	})
+
+	return item, err
+}
+
+// PutTrashItem adds an item to the trash as it is, under a new id, e.g.
+// when a site is copied.
+func (db *cachedDatabase) PutTrashItem(item *TrashItem) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		tb, err := tx.CreateBucketIfNotExists([]byte(BUCKET_TRASH))
+		if err != nil {
+			return err
+		}
+
+		if item.ID, err = tb.NextSequence(); err != nil {
+			return err
+		}
+
+		return save(tb, itob(item.ID), item)
+	})
+}
+
+// purgeHistory deletes the history of a purged item, unless it is shared
+// with a live item or another trashed one of the same slug.
+func purgeHistory(tx *bolt.Tx, item TrashItem) error {
+	hb := tx.Bucket([]byte(BUCKET_HISTORY))
+	if hb == nil || hb.Bucket(itemKey(item.Kind, item.Slug)) == nil {
+		return nil
+	}
+
+	bucket := BUCKET_PROJECTS
+	if item.Kind == RevisionContent {
+		bucket = BUCKET_CONTENT
+	}
+
+	if tx.Bucket([]byte(bucket)).Get([]byte(item.Slug)) != nil {
+		return nil
+	}
+
+	c := tx.Bucket([]byte(BUCKET_TRASH)).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var other TrashItem
+		if err := json.Unmarshal(v, &other); err != nil {
+			return err
+		}
+
+		if other.Kind == item.Kind && other.Slug == item.Slug {
+			return nil
+		}
+	}
+
+	return hb.DeleteBucket(itemKey(item.Kind, item.Slug))
+}
+
+func (db *cachedDatabase) invalidateItem(kind RevisionKind, slug string) {
+	for _, k := range itemCacheKeys(kind, slug) {
+		db.cache.Delete(k)
+	}
+}
+
+// SQLite
+func (db *sqliteDatabase) trash(kind RevisionKind, table string, slug string) error {
+	return db.update(func(tx *sql.Tx) error {
+		return trashSqlItem(tx, kind, table, slug)
+	})
+}
+
+func trashSqlItem(tx *sql.Tx, kind RevisionKind, table string, slug string) error {
+	var data string
+	err := tx.QueryRow(`SELECT data FROM `+table+` WHERE slug = ?`, slug).Scan(&data)
+	if err == sql.ErrNoRows {
+		return notFound(kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	item, err := NewTrashItem(kind, []byte(data))
+	if err != nil {
+		return err
+	}
+
+	var route Route
+	err = tx.QueryRow(`SELECT slug, title FROM routes WHERE slug = ?`, slug).Scan(&route.Slug, &route.Title)
+	switch err {
+	case nil:
+		item.Route = &route
+
+		if _, err := tx.Exec(`DELETE FROM routes WHERE slug = ?`, slug); err != nil {
+			return err
+		}
+	case sql.ErrNoRows:
+	default:
+		return err
+	}
+
+	m, err := readSqlMenu(tx)
+	if err != nil {
+		return err
+	}
+
+	if item.MenuPosition, item.InMenu = removeMenuEntry(m, slug); item.InMenu {
+		if err := putMenu(tx, m); err != nil {
+			return err
+		}
+	}
+
+	if err := insertSqlTrashItem(tx, &item); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM `+table+` WHERE slug = ?`, slug)
+	return err
+}
+
+func (t *sqliteTx) DeleteProject(slug string) error {
+	if err := trashSqlItem(t.tx, RevisionProject, "projects", slug); err != nil {
+		return err
+	}
+
+	t.ops.delete(itemCacheKeys(RevisionProject, slug)...)
+
+	return nil
+}
+
+func (t *sqliteTx) DeleteContent(slug string) error {
+	if err := trashSqlItem(t.tx, RevisionContent, "contents", slug); err != nil {
+		return err
+	}
+
+	t.ops.delete(itemCacheKeys(RevisionContent, slug)...)
+
+	return nil
+}
+
+func (db *sqliteDatabase) GetTrash(ctx context.Context) ([]TrashItem, error) {
+	items := make([]TrashItem, 0)
+	err := db.queryRecords(ctx, `SELECT data FROM trash ORDER BY id DESC`, func(data []byte) error {
+		var item TrashItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+
+	if err != nil {
+		db.logger.Error("cannot get trash", zap.Error(err))
+		return items, ErrDatabase
+	}
+
+	return items, nil
+}
+
+func (db *sqliteDatabase) RestoreTrashItem(id uint64) (TrashItem, error) {
+	var item TrashItem
+	err := db.update(func(tx *sql.Tx) error {
+		err := readSqlTrashItem(tx, id, &item)
+		if err != nil {
+			return err
+		}
+
+		table := "projects"
+		if item.Kind == RevisionContent {
+			table = "contents"
+		}
+
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE slug = ?`, item.Slug).Scan(&n); err != nil {
+			return err
+		}
+
+		if n > 0 {
+			return exists(item.Kind)
+		}
+
+		var r struct {
+			Title, Subtitle string
+			Published       time.Time
+		}
+		if err := json.Unmarshal(item.Data, &r); err != nil {
+			return err
+		}
+
+		err = putRecord(tx, table, item.Slug, r.Title, r.Subtitle, r.Published, item.Data)
+		if err != nil {
+			return err
+		}
+
+		if item.Route != nil {
+			_, err := tx.Exec(`INSERT OR REPLACE INTO routes (slug, title) VALUES (?, ?)`, item.Route.Slug, item.Route.Title)
+			if err != nil {
+				return err
+			}
+		}
+
+		if item.InMenu && item.Route != nil {
+			m, err := readSqlMenu(tx)
+			if err != nil {
+				return err
+			}
+
+			insertMenuEntry(m, item.MenuPosition, *item.Route)
+
+			if err := putMenu(tx, m); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`DELETE FROM trash WHERE id = ?`, id)
+		return err
+	})
+
+	if err != nil {
+		return item, err
+	}
+
+	db.invalidateItem(item.Kind, item.Slug)
+
+	return item, nil
+}
+
+func (db *sqliteDatabase) PurgeTrashItem(id uint64) (TrashItem, error) {
+	var item TrashItem
+	err := db.update(func(tx *sql.Tx) error {
+		if err := readSqlTrashItem(tx, id, &item); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM trash WHERE id = ?`, id); err != nil {
+			return err
+		}
+
+		table := "projects"
+		if item.Kind == RevisionContent {
+			table = "contents"
+		}
+
+		// History shared with a live item or another trashed one stays.
+		_, err := tx.Exec(`DELETE FROM revisions WHERE kind = ? AND slug = ?
+			AND NOT EXISTS (SELECT 1 FROM `+table+` WHERE slug = ?)
+			AND NOT EXISTS (SELECT 1 FROM trash WHERE kind = ? AND slug = ?)`,
+			string(item.Kind), item.Slug, item.Slug, string(item.Kind), item.Slug)
+		return err
+	})
+
+	return item, err
+}
+
+func (db *sqliteDatabase) PutTrashItem(item *TrashItem) error {
+	return db.update(func(tx *sql.Tx) error {
+		return insertSqlTrashItem(tx, item)
+	})
+}
+
+func (db *sqliteDatabase) invalidateItem(kind RevisionKind, slug string) {
+	for _, k := range itemCacheKeys(kind, slug) {
+		db.cache.Delete(k)
+	}
+}
+
+func readSqlMenu(tx *sql.Tx) (Menu, error) {
+	var m Menu = make(Menu)
+
+	rows, err := tx.Query(`SELECT position, slug, title FROM menu ORDER BY position`)
+	if err != nil {
+		return m, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			i int
+			r Route
+		)
+		if err := rows.Scan(&i, &r.Slug, &r.Title); err != nil {
+			return m, err
+		}
+		m[i] = r
+	}
+
+	return m, rows.Err()
+}
+
+func insertSqlTrashItem(tx *sql.Tx, item *TrashItem) error {
+	res, err := tx.Exec(`INSERT INTO trash (kind, slug, deleted, data) VALUES (?, ?, ?, '')`,
+		string(item.Kind), item.Slug, item.Deleted.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	item.ID = uint64(id)
+
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE trash SET data = ? WHERE id = ?`, string(buf), id)
+	return err
+}
+
+func readSqlTrashItem(tx *sql.Tx, id uint64, item *TrashItem) error {
+	var data string
+	err := tx.QueryRow(`SELECT data FROM trash WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return ErrTrashItemNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal([]byte(data), item)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"context"
+	crand "crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -52,6 +54,16 @@ func GenerateRandomString(n int) string {
 	return fmt.Sprintf("%X", b)
 }
 
+// GenerateSecret returns n random bytes from a cryptographic source,
+// encoded for use in URLs. It is meant for passwords and tokens.
+func GenerateSecret(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
 func IsMapValid(m map[string]string) bool {
 	for _, v := range m {
 		if v == "" {
@@ -226,9 +238,25 @@ func (s *SitemapBuilder) Build() {
 		s.logger.Error("cannot get projects to update sitemap", zap.Error(err))
 	}
 
+	cs, err := s.db.GetContents(ctx)
+	if err != nil {
+		s.logger.Error("cannot get contents to update sitemap", zap.Error(err))
+	}
+
+	var (
+		now    = time.Now()
+		hidden = hiddenContents(cs, now)
+	)
+
+	p = publicProjects(p, now)
+
 	var urls []Url = make([]Url, 0, len(r)+len(p))
 
 	for _, v := range r {
+		if hidden[v.Slug] {
+			continue
+		}
+
 		var a string
 		if v.Slug == "home" {
 			a = u.String()
@@ -280,14 +308,7 @@ func (s *ThemeScanner) LoadThemes() map[string]Theme {
 
 	dirs, _ := ioutil.ReadDir(ThemesPath)
 	for _, d := range dirs {
-		data, err := ioutil.ReadFile(filepath.Join(ThemesPath, d.Name(), ThemeConfig))
-		if err != nil {
-			continue
-		}
-
-		var t Theme
-
-		err = json.Unmarshal(data, &t)
+		t, err := s.LoadTheme(d.Name())
 		if err != nil {
 			continue
 		}
@@ -297,3 +318,16 @@ func (s *ThemeScanner) LoadThemes() map[string]Theme {
 
 	return ts
 }
+
+func (s *ThemeScanner) LoadTheme(path string) (Theme, error) {
+	var t Theme
+
+	data, err := ioutil.ReadFile(filepath.Join(ThemesPath, path, ThemeConfig))
+	if err != nil {
+		return t, err
+	}
+
+	err = json.Unmarshal(data, &t)
+
+	return t, err
+}